@@ -0,0 +1,54 @@
+package cmds
+
+import (
+	"iptv/internal/app/iptv"
+	"iptv/internal/pkg/capture"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	recordDir string
+	replayDir string
+)
+
+// addCaptureFlags 增加录制和回放运营商HTTP交互的命令参数
+func addCaptureFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&recordDir, "record", "", "将与运营商服务器的HTTP交互（已脱敏）录制到指定目录，用于反馈和排查问题。")
+	cmd.Flags().StringVar(&replayDir, "replay", "", "使用指定目录中录制的HTTP交互代替运营商服务器，用于离线复现问题。")
+	cmd.MarkFlagsMutuallyExclusive("record", "replay")
+}
+
+// newHTTPClient 创建请求运营商服务器的HTTP客户端，需在配置文件校验之后调用
+func newHTTPClient() (*http.Client, error) {
+	if recordDir == "" && replayDir == "" {
+		return &http.Client{}, nil
+	}
+
+	// 获取当前平台需要脱敏的字段
+	provider, err := iptv.GetProvider(conf.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	// L()：获取全局logger
+	logger := zap.L()
+
+	if recordDir != "" {
+		recorder, err := capture.NewRecorder(recordDir, http.DefaultTransport, provider.SensitiveFields)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Recording the HTTP traffic with the IPTV server.", zap.String("dir", recordDir))
+		return &http.Client{Transport: recorder}, nil
+	}
+
+	replayer, err := capture.NewReplayer(replayDir, provider.SensitiveFields)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Replaying the recorded HTTP traffic instead of the IPTV server.", zap.String("dir", replayDir))
+	return &http.Client{Transport: replayer}, nil
+}
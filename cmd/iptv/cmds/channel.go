@@ -3,13 +3,10 @@ package cmds
 import (
 	"errors"
 	"iptv/internal/app/iptv"
-	"iptv/internal/app/iptv/hwctc"
 	"iptv/internal/pkg/util"
-	"net/http"
 	"os"
 	"path"
 	"slices"
-	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -25,6 +22,7 @@ var (
 	format            string
 	catchupSource     string
 	multicastFirst    bool
+	urlMode           string
 )
 
 func NewChannelCLI() *cobra.Command {
@@ -40,11 +38,14 @@ func NewChannelCLI() *cobra.Command {
 				return err
 			}
 
-			// 创建IPTV客户端
-			i, err := hwctc.NewClient(&http.Client{
-				Timeout: 10 * time.Second,
-			}, conf.HWCTC, conf.Key, conf.ServerHost, conf.Headers,
-				conf.ChExcludeRule, conf.ChGroupRulesList, conf.ChLogoRuleList)
+			// 创建HTTP客户端，可录制或回放与运营商服务器的交互
+			httpClient, err := newHTTPClient()
+			if err != nil {
+				return err
+			}
+
+			// 根据配置的平台类型创建IPTV客户端
+			i, err := conf.NewIPTVClient(httpClient)
 			if err != nil {
 				return err
 			}
@@ -59,9 +60,15 @@ func NewChannelCLI() *cobra.Command {
 				return errors.New("no channels found")
 			}
 
+			// 过滤掉特殊频道，合并同一频道的不同清晰度版本，并对频道进行重命名、修改频道号以及排序
+			channels = conf.ChCustomizer.Apply(conf.ChMerger.Merge(conf.ChannelRules().Apply(channels)))
+
 			if !slices.Contains(supportFileFormat, format) {
 				return errors.New("file format not support")
 			}
+			if !iptv.IsValidURLMode(urlMode) {
+				return errors.New("url mode not support")
+			}
 
 			// 在当前目录中创建频道文件
 			outFileName := fileName + "." + format
@@ -81,19 +88,19 @@ func NewChannelCLI() *cobra.Command {
 			switch format {
 			case supportFileFormat[0]:
 				// 将获取到的频道列表转换为TXT格式
-				content, err = iptv.ToTxtFormat(channels, udpxyURL, multicastFirst)
+				content, err = iptv.ToTxtFormat(channels, udpxyURL, multicastFirst, urlMode)
 				if err != nil {
 					return err
 				}
 			case supportFileFormat[1]:
 				// 将获取到的频道列表转换为M3U格式
-				content, err = iptv.ToM3UFormat(channels, udpxyURL, catchupSource, multicastFirst, "")
+				content, err = iptv.ToM3UFormat(channels, udpxyURL, catchupSource, multicastFirst, urlMode, "", "")
 				if err != nil {
 					return err
 				}
 			case supportFileFormat[2]:
 				// 将获取到的频道列表转换为PLS(playlist)格式
-				content, err = iptv.ToPLSFormat(channels, udpxyURL, multicastFirst)
+				content, err = iptv.ToPLSFormat(channels, udpxyURL, multicastFirst, urlMode)
 				if err != nil {
 					return err
 				}
@@ -115,6 +122,9 @@ func NewChannelCLI() *cobra.Command {
 	channelCmd.Flags().StringVarP(&format, "format", "f", "m3u", "生成的直播源文件格式，e.g `m3u,txt或pls`。")
 	channelCmd.Flags().StringVarP(&catchupSource, "catchup-source", "s", "playseek=${(b)yyyyMMddHHmmss}-${(e)yyyyMMddHHmmss}", "回看的请求格式字符串，会追加在时移地址后面。")
 	channelCmd.Flags().BoolVarP(&multicastFirst, "multicast-first", "m", false, "当频道存在多个URL地址时，是否优先使用组播地址。缺省为false。")
+	channelCmd.Flags().StringVar(&urlMode, "url-mode", iptv.URLModeSingle, "频道URL地址的输出方式，e.g `single,all或hash`。all输出所有地址，hash仅用于txt格式，使用#连接所有地址。")
+
+	addCaptureFlags(channelCmd)
 
 	return channelCmd
 }
@@ -0,0 +1,80 @@
+package cmds
+
+import (
+	"context"
+	"iptv/internal/app/config"
+	"iptv/internal/app/router"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// watchConfig 定时检查配置文件是否修改，并监听SIGHUP信号，重新加载配置
+func watchConfig(ctx context.Context, fPath string, interval time.Duration) {
+	logger := zap.L()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		// interval为0时不检查配置文件的修改
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		lastModTime, lastSize := statConfig(fPath)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logger.Info("Received SIGHUP, reload the config.", zap.String("path", fPath))
+			case <-tick:
+				modTime, size := statConfig(fPath)
+				if modTime.Equal(lastModTime) && size == lastSize {
+					continue
+				}
+				lastModTime, lastSize = modTime, size
+				logger.Info("The config file has been modified, reload it.", zap.String("path", fPath))
+			}
+
+			if err := reloadConfig(fPath); err != nil {
+				logger.Error("Failed to reload the config, keep using the current one.", zap.String("path", fPath), zap.Error(err))
+			}
+		}
+	}()
+}
+
+// statConfig 获取配置文件的修改时间和大小，文件不存在时返回零值
+func statConfig(fPath string) (time.Time, int64) {
+	info, err := os.Stat(fPath)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// reloadConfig 读取并严格校验配置文件，校验通过后替换正在使用的配置
+func reloadConfig(fPath string) error {
+	newConf, err := config.Load(fPath)
+	if err != nil {
+		return err
+	}
+	if err = newConf.CheckRules(); err != nil {
+		return err
+	}
+	if err = newConf.Validate(); err != nil {
+		return err
+	}
+
+	router.ReloadConfig(newConf)
+	return nil
+}
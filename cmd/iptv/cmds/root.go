@@ -2,6 +2,7 @@ package cmds
 
 import (
 	"iptv/internal/app/config"
+	_ "iptv/internal/app/iptv/hwctc" // 注册hw平台
 	"iptv/internal/pkg/util"
 	"os"
 	"path/filepath"
@@ -11,6 +12,8 @@ import (
 
 var (
 	cfgFile string
+	// 实际使用的配置文件路径
+	cfgPath string
 
 	conf *config.Config
 )
@@ -60,6 +63,7 @@ func initConfig() {
 	}
 
 	// 读取配置文件
+	cfgPath = fPath
 	conf, err = config.Load(fPath)
 	cobra.CheckErr(err)
 }
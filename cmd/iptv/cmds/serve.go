@@ -16,10 +16,12 @@ import (
 var httpConfig HttpConfig
 
 type HttpConfig struct {
-	Port     int           `json:"port"`
-	UdpxyURL string        `json:"udpxyURL"`
-	Interval time.Duration `json:"interval"`
-	LiveFile string        `json:"liveFile"`
+	Port          int           `json:"port"`
+	UdpxyURL      string        `json:"udpxyURL"`
+	Interval      time.Duration `json:"interval"`
+	LiveFile      string        `json:"liveFile"`
+	DataDir       string        `json:"dataDir"`
+	WatchInterval time.Duration `json:"watchInterval"`
 }
 
 func NewServeCLI() *cobra.Command {
@@ -44,16 +46,31 @@ func NewServeCLI() *cobra.Command {
 				router.LoadLivesConfig(&lives)
 			}
 
-			// 检查自动更新间隔不能太短
-			if httpConfig.Interval < 15*time.Minute {
+			// 校验配置文件
+			if err := conf.Validate(); err != nil {
+				return err
+			}
+
+			// 检查自动更新间隔不能太短，频道列表和节目单均配置了执行计划时不使用该间隔
+			schedule := conf.Schedule
+			if (len(schedule.ChannelSchedules) == 0 || len(schedule.EPGSchedules) == 0) && httpConfig.Interval < 15*time.Minute {
 				return errors.New("interval cannot be less than 15 minutes")
 			}
 
+			// 创建HTTP客户端，可录制或回放与运营商服务器的交互
+			httpClient, err := newHTTPClient()
+			if err != nil {
+				return err
+			}
+
 			// 创建并启动HTTP服务
-			r, err := router.NewEngine(cmd.Context(), conf, httpConfig.Interval, httpConfig.UdpxyURL)
+			r, err := router.NewEngine(cmd.Context(), conf, httpClient, httpConfig.Interval, httpConfig.UdpxyURL, httpConfig.DataDir)
 			if err != nil {
 				return err
 			}
+			// 监听配置文件的修改及SIGHUP信号，热加载配置
+			watchConfig(cmd.Context(), cfgPath, httpConfig.WatchInterval)
+
 			// L()：获取全局logger
 			logger := zap.L()
 			logger.Info("Start the http service.", zap.String("port", strconv.Itoa(httpConfig.Port)))
@@ -67,8 +84,12 @@ func NewServeCLI() *cobra.Command {
 
 	serveCmd.Flags().IntVarP(&httpConfig.Port, "port", "p", 8080, "HTTP服务的监听端口。")
 	serveCmd.Flags().StringVarP(&httpConfig.UdpxyURL, "udpxy", "u", "", "如果有安装udpxy进行组播转单播，则请配置HTTP地址。支持同时配置内外网对应的多个udpxy的地址。e.g `http://192.168.1.1:4022或inner=http://192.168.1.1:4022,outer=http://udpxy.iptv.com:4022`。")
-	serveCmd.Flags().DurationVarP(&httpConfig.Interval, "interval", "i", 24*time.Hour, "自动刷新频道列表和节目单的间隔时间，e.g `24h或15m`。")
+	serveCmd.Flags().DurationVarP(&httpConfig.Interval, "interval", "i", 24*time.Hour, "自动刷新频道列表和节目单的间隔时间，e.g `24h或15m`。配置文件中设置了schedule的执行计划时，以执行计划为准。")
 	serveCmd.Flags().StringVarP(&httpConfig.LiveFile, "livefile", "l", "", "加载FongMi的直播配置json文件，并提供查询接口。")
+	serveCmd.Flags().StringVarP(&httpConfig.DataDir, "data-dir", "d", "", "频道列表和节目单缓存文件的存放目录，缺省为程序所在目录下的data目录。")
+	serveCmd.Flags().DurationVar(&httpConfig.WatchInterval, "watch-interval", 10*time.Second, "检查配置文件是否修改的间隔时间，修改后自动热加载，为0时不检查（仍可通过SIGHUP信号热加载）。")
+
+	addCaptureFlags(serveCmd)
 
 	return serveCmd
 }
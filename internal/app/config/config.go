@@ -2,15 +2,32 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"iptv/internal/app/iptv"
-	"iptv/internal/app/iptv/hwctc"
+	"iptv/internal/pkg/cron"
+	"iptv/internal/pkg/util"
+	"net/http"
 	"os"
 	"regexp"
+	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	defaultRefreshRetries    = 2
+	defaultRefreshBackoff    = 30 * time.Second
+	defaultRefreshMaxBackoff = 10 * time.Minute
+)
+
+const (
+	defaultPlatform         = "hwctc"
+	defaultEPGRetentionDays = 8
+	defaultEPGTimezone      = "Asia/Shanghai"
+	defaultEPGLang          = "zh"
+)
+
 type OptionChannelGroupRules struct {
 	Name  string   `json:"name" yaml:"name"`   // 分组名称
 	Rules []string `json:"rules" yaml:"rules"` // 分组规则
@@ -21,8 +38,77 @@ type OptionChannelLogoRule struct {
 	Rule string `json:"rule" yaml:"rule"` // 台标匹配规则
 }
 
+const (
+	CatchupModeProxy    = "proxy"
+	CatchupModeRedirect = "redirect"
+)
+
 type CatchupConfig struct {
-	Sources map[string]string `json:"sources" yaml:"sources"` // 回看请求的参数
+	Sources map[string]string `json:"sources" yaml:"sources"`               // 回看请求的参数
+	Mode    string            `json:"mode,omitempty" yaml:"mode,omitempty"` // 回看地址的生成方式，可选值：proxy, redirect，为空时直接使用运营商的回看地址
+}
+
+type EPGConfig struct {
+	RetentionDays int            `json:"retentionDays" yaml:"retentionDays"`           // 节目单保留的历史天数
+	Timezone      string         `json:"timezone,omitempty" yaml:"timezone,omitempty"` // 节目单时间所在的时区，缺省为Asia/Shanghai
+	Lang          string         `json:"lang,omitempty" yaml:"lang,omitempty"`         // xmltv中的语言代码，缺省为zh
+	Location      *time.Location `json:"-" yaml:"-"`                                   // Validate()时进行填充
+}
+
+type RelayConfig struct {
+	Enable        bool          `json:"enable" yaml:"enable"`                       // 是否启用内置的组播转单播服务
+	InterfaceName string        `json:"interfaceName" yaml:"interfaceName"`         // 接收组播的网络接口名称
+	Timeout       time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"` // 组播无数据的超时时间
+}
+
+type HealthConfig struct {
+	MaxChannelAge time.Duration `json:"maxChannelAge,omitempty" yaml:"maxChannelAge,omitempty"` // 频道列表的最大缓存时长，超过时/status返回503，缺省为刷新间隔的2倍
+}
+
+type ScheduleConfig struct {
+	Channels   []string      `json:"channels,omitempty" yaml:"channels,omitempty"`     // 刷新频道列表的cron表达式，为空时按serve命令的-i参数定时刷新
+	EPG        []string      `json:"epg,omitempty" yaml:"epg,omitempty"`               // 刷新节目单的cron表达式，为空时按serve命令的-i参数定时刷新
+	EPGToday   []string      `json:"epgToday,omitempty" yaml:"epgToday,omitempty"`     // 只刷新当天节目单的cron表达式，适合频繁执行，为空时不单独刷新
+	Jitter     time.Duration `json:"jitter,omitempty" yaml:"jitter,omitempty"`         // 每次定时刷新随机延迟的最大时长
+	Retries    int           `json:"retries,omitempty" yaml:"retries,omitempty"`       // 刷新失败时的最大重试次数，缺省为2，为负数时不重试
+	Backoff    time.Duration `json:"backoff,omitempty" yaml:"backoff,omitempty"`       // 首次重试前的等待时间，之后每次翻倍，缺省为30s
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"` // 重试前等待时间的上限，缺省为10m
+
+	ChannelSchedules  []cron.Schedule `json:"-" yaml:"-"` // Validate()时进行填充
+	EPGSchedules      []cron.Schedule `json:"-" yaml:"-"` // Validate()时进行填充
+	EPGTodaySchedules []cron.Schedule `json:"-" yaml:"-"` // Validate()时进行填充
+}
+
+type AdminConfig struct {
+	Token string `json:"token,omitempty" yaml:"token,omitempty"` // 管理接口的访问令牌，为空时不启用管理接口
+}
+
+type OptionChannelView struct {
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"` // 包含规则，匹配任意一条即包含，为空时包含所有频道
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"` // 排除规则，匹配任意一条即排除
+}
+
+type OptionChannelMerge struct {
+	Enable   bool                    `json:"enable" yaml:"enable"`                         // 是否合并同一频道的不同清晰度版本
+	KeyRules []OptionChannelLogoRule `json:"keyRules,omitempty" yaml:"keyRules,omitempty"` // 识别同一频道的规则，为空时使用频道台标名称
+	Priority []string                `json:"priority,omitempty" yaml:"priority,omitempty"` // 清晰度的优先级，如：4K、HD、SD
+}
+
+type OptionChannelRename struct {
+	Rule string `json:"rule" yaml:"rule"` // 频道名称的匹配规则
+	Name string `json:"name" yaml:"name"` // 替换后的频道名称
+}
+
+type OptionChannelPin struct {
+	Channel  string `json:"channel" yaml:"channel"`   // 频道ID或频道名称
+	Position int    `json:"position" yaml:"position"` // 从1开始的位置
+}
+
+type OptionChannelCustom struct {
+	Rename     []OptionChannelRename `json:"rename,omitempty" yaml:"rename,omitempty"`         // 频道重命名规则
+	Numbers    map[string]string     `json:"numbers,omitempty" yaml:"numbers,omitempty"`       // 自定义频道号
+	Pins       []OptionChannelPin    `json:"pins,omitempty" yaml:"pins,omitempty"`             // 固定位置的频道
+	GroupOrder []string              `json:"groupOrder,omitempty" yaml:"groupOrder,omitempty"` // 分组的排列顺序
 }
 
 type Config struct {
@@ -30,8 +116,8 @@ type Config struct {
 	ServerHost string            `json:"serverHost" yaml:"serverHost"` // 必填，HTTP请求的IPTV服务器地址端口
 	Headers    map[string]string `json:"headers" yaml:"headers"`       // 自定义HTTP请求头
 
-	OptionChExcludeRule string         `json:"chExcludeRule" yaml:"chExcludeRule"` // 频道的过滤规则
-	ChExcludeRule       *regexp.Regexp `json:"-" yaml:"-"`                         // Validate()时进行填充
+	OptionChExcludeRule string            `json:"chExcludeRule" yaml:"chExcludeRule"` // 频道的过滤规则
+	ChExcludeRule       *iptv.ChannelRule `json:"-" yaml:"-"`                         // Validate()时进行填充
 
 	OptionChGroupRulesList []OptionChannelGroupRules `json:"chGroupRules" yaml:"chGroupRules"` // 自定义频道分组规则
 	ChGroupRulesList       []iptv.ChannelGroupRules  `json:"-" yaml:"-"`                       // Validate()时进行填充
@@ -39,9 +125,31 @@ type Config struct {
 	OptionChLogoRuleList []OptionChannelLogoRule `json:"logos" yaml:"logos"` // 自定义台标匹配规则
 	ChLogoRuleList       []iptv.ChannelLogoRule  `json:"-" yaml:"-"`         // Validate()时进行填充
 
+	OptionChViews map[string]OptionChannelView `json:"views,omitempty" yaml:"views,omitempty"` // 自定义频道列表的视图
+	ChViews       map[string]*iptv.ChannelView `json:"-" yaml:"-"`                             // Validate()时进行填充
+
+	OptionChMerge *OptionChannelMerge `json:"chMerge,omitempty" yaml:"chMerge,omitempty"` // 合并同一频道的不同清晰度版本
+	ChMerger      *iptv.ChannelMerger `json:"-" yaml:"-"`                                 // Validate()时进行填充，未启用时为nil
+
+	OptionChCustom *OptionChannelCustom    `json:"chCustom,omitempty" yaml:"chCustom,omitempty"` // 频道的重命名、频道号及排序配置
+	ChCustomizer   *iptv.ChannelCustomizer `json:"-" yaml:"-"`                                   // Validate()时进行填充
+
 	Catchup *CatchupConfig `json:"catchup" yaml:"catchup"` // 回看请求参数配置
 
-	HWCTC *hwctc.Config `json:"hwctc,omitempty" yaml:"hwctc,omitempty"` // hw平台相关设置
+	Udpxy string `json:"udpxy,omitempty" yaml:"udpxy,omitempty"` // udpxy的地址，格式同serve命令的-u参数，设置后优先于-u参数
+
+	EPG *EPGConfig `json:"epg" yaml:"epg"` // 节目单相关配置
+
+	Relay *RelayConfig `json:"relay,omitempty" yaml:"relay,omitempty"` // 内置的组播转单播服务配置
+
+	Health *HealthConfig `json:"health,omitempty" yaml:"health,omitempty"` // 健康检查相关配置
+
+	Admin *AdminConfig `json:"admin,omitempty" yaml:"admin,omitempty"` // 管理接口相关配置
+
+	Schedule *ScheduleConfig `json:"schedule,omitempty" yaml:"schedule,omitempty"` // 定时刷新相关配置
+
+	Platform  string               `json:"platform,omitempty" yaml:"platform,omitempty"` // IPTV平台类型，缺省为hwctc
+	Providers map[string]yaml.Node `json:"-" yaml:",inline"`                             // 各平台相关设置（如hwctc），由对应平台自行解析和校验
 }
 
 func (c *Config) Validate() error {
@@ -54,9 +162,21 @@ func (c *Config) Validate() error {
 	// L()：获取全局logger
 	logger := zap.L()
 
+	// 设置缺省的平台类型
+	if c.Platform == "" {
+		c.Platform = defaultPlatform
+	}
+
+	// 平台相关设置以平台名称作为顶层的key，其余未知的key多为拼写错误，不能被静默忽略
+	for _, key := range util.SortedMapKeys(c.Providers) {
+		if _, err := iptv.GetProvider(key); err != nil {
+			return fmt.Errorf("unknown config key %q: it is neither a supported option nor a registered platform", key)
+		}
+	}
+
 	// 填充频道的过滤规则
 	if c.OptionChExcludeRule != "" {
-		rule, err := regexp.Compile(c.OptionChExcludeRule)
+		rule, err := iptv.ParseChannelRule(c.OptionChExcludeRule)
 		if err != nil {
 			logger.Warn("The channel exclusion rule is incorrect. Skip it.", zap.String("chExcludeRule", c.OptionChExcludeRule), zap.Error(err))
 		} else {
@@ -75,9 +195,9 @@ func (c *Config) Validate() error {
 			continue
 		}
 
-		rules := make([]*regexp.Regexp, 0, len(opChGroupRules.Rules))
+		rules := make([]*iptv.ChannelRule, 0, len(opChGroupRules.Rules))
 		for _, ruleStr := range opChGroupRules.Rules {
-			rule, err := regexp.Compile(ruleStr)
+			rule, err := iptv.ParseChannelRule(ruleStr)
 			if err != nil {
 				logger.Warn("The channel group rule is incorrect. Skip it.", zap.String("name", opChGroupRules.Name), zap.String("rule", ruleStr), zap.Error(err))
 				continue
@@ -116,6 +236,79 @@ func (c *Config) Validate() error {
 		})
 	}
 
+	// 填充频道列表的视图
+	c.ChViews = make(map[string]*iptv.ChannelView, len(c.OptionChViews))
+	for name, opView := range c.OptionChViews {
+		c.ChViews[name] = &iptv.ChannelView{
+			Include: parseChannelRules(name, opView.Include),
+			Exclude: parseChannelRules(name, opView.Exclude),
+		}
+	}
+
+	// 填充频道的合并配置
+	c.ChMerger = nil
+	if c.OptionChMerge != nil && c.OptionChMerge.Enable {
+		c.ChMerger = &iptv.ChannelMerger{
+			KeyRules: make([]iptv.ChannelLogoRule, 0, len(c.OptionChMerge.KeyRules)),
+			Priority: c.OptionChMerge.Priority,
+		}
+		for _, opKeyRule := range c.OptionChMerge.KeyRules {
+			if opKeyRule.Name == "" || opKeyRule.Rule == "" {
+				logger.Warn("The channel merge key rule is empty. Skip it.", zap.String("name", opKeyRule.Name), zap.String("rule", opKeyRule.Rule))
+				continue
+			}
+
+			rule, err := regexp.Compile(opKeyRule.Rule)
+			if err != nil {
+				logger.Warn("The channel merge key rule is incorrect. Skip it.", zap.String("name", opKeyRule.Name), zap.String("rule", opKeyRule.Rule), zap.Error(err))
+				continue
+			}
+
+			c.ChMerger.KeyRules = append(c.ChMerger.KeyRules, iptv.ChannelLogoRule{
+				Name: opKeyRule.Name,
+				Rule: rule,
+			})
+		}
+	}
+
+	// 填充频道的重命名、频道号及排序配置
+	c.ChCustomizer = &iptv.ChannelCustomizer{}
+	if c.OptionChCustom != nil {
+		for _, opRename := range c.OptionChCustom.Rename {
+			if opRename.Rule == "" {
+				logger.Warn("The channel rename rule is empty. Skip it.", zap.String("name", opRename.Name))
+				continue
+			}
+
+			rule, err := regexp.Compile(opRename.Rule)
+			if err != nil {
+				logger.Warn("The channel rename rule is incorrect. Skip it.", zap.String("name", opRename.Name), zap.String("rule", opRename.Rule), zap.Error(err))
+				continue
+			}
+
+			c.ChCustomizer.RenameRules = append(c.ChCustomizer.RenameRules, iptv.ChannelRenameRule{
+				Name: opRename.Name,
+				Rule: rule,
+			})
+		}
+
+		c.ChCustomizer.Numbers = c.OptionChCustom.Numbers
+
+		for _, opPin := range c.OptionChCustom.Pins {
+			if opPin.Channel == "" || opPin.Position <= 0 {
+				logger.Warn("The channel pin is incorrect. Skip it.", zap.String("channel", opPin.Channel), zap.Int("position", opPin.Position))
+				continue
+			}
+
+			c.ChCustomizer.Pins = append(c.ChCustomizer.Pins, iptv.ChannelPin{
+				Channel:  opPin.Channel,
+				Position: opPin.Position,
+			})
+		}
+
+		c.ChCustomizer.GroupOrder = c.OptionChCustom.GroupOrder
+	}
+
 	// 回看请求参数
 	if c.Catchup == nil {
 		c.Catchup = &CatchupConfig{
@@ -130,10 +323,149 @@ func (c *Config) Validate() error {
 			"1": "playseek={utc:YmdHMS}-{utcend:YmdHMS}",
 		}
 	}
+	if c.Catchup.Mode != "" && c.Catchup.Mode != CatchupModeProxy && c.Catchup.Mode != CatchupModeRedirect {
+		logger.Warn("The catchup mode is incorrect. Skip it.", zap.String("mode", c.Catchup.Mode))
+		c.Catchup.Mode = ""
+	}
+
+	// 节目单保留的历史天数
+	if c.EPG == nil {
+		c.EPG = &EPGConfig{}
+	}
+	if c.EPG.RetentionDays <= 0 {
+		c.EPG.RetentionDays = defaultEPGRetentionDays
+	}
+	if c.EPG.Lang == "" {
+		c.EPG.Lang = defaultEPGLang
+	}
+	if c.EPG.Timezone == "" {
+		c.EPG.Timezone = defaultEPGTimezone
+	}
+	location, err := time.LoadLocation(c.EPG.Timezone)
+	if err != nil {
+		// 系统中缺少时区数据时（如OpenWrt），使用固定的东八区
+		logger.Warn("Failed to load the EPG timezone. Use UTC+8 instead.", zap.String("timezone", c.EPG.Timezone), zap.Error(err))
+		location = time.FixedZone("UTC+8", 8*60*60)
+	}
+	c.EPG.Location = location
+
+	// 定时刷新的计划及失败重试
+	if c.Schedule == nil {
+		c.Schedule = &ScheduleConfig{}
+	}
+	if c.Schedule.Retries == 0 {
+		c.Schedule.Retries = defaultRefreshRetries
+	}
+	if c.Schedule.Backoff <= 0 {
+		c.Schedule.Backoff = defaultRefreshBackoff
+	}
+	if c.Schedule.MaxBackoff <= 0 {
+		c.Schedule.MaxBackoff = defaultRefreshMaxBackoff
+	}
+	if c.Schedule.ChannelSchedules, err = parseSchedules(c.Schedule.Channels, location); err != nil {
+		return fmt.Errorf("invalid channel schedule: %w", err)
+	}
+	if c.Schedule.EPGSchedules, err = parseSchedules(c.Schedule.EPG, location); err != nil {
+		return fmt.Errorf("invalid EPG schedule: %w", err)
+	}
+	if c.Schedule.EPGTodaySchedules, err = parseSchedules(c.Schedule.EPGToday, location); err != nil {
+		return fmt.Errorf("invalid today's EPG schedule: %w", err)
+	}
 
 	return nil
 }
 
+// parseSchedules 解析定时刷新的cron表达式，有误或永远不会执行的表达式返回错误
+func parseSchedules(specs []string, location *time.Location) ([]cron.Schedule, error) {
+	schedules := make([]cron.Schedule, 0, len(specs))
+	for _, spec := range specs {
+		schedule, err := cron.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if schedule.Next(time.Now().In(location)).IsZero() {
+			return nil, fmt.Errorf("the cron spec %q never runs", spec)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// CheckRules 严格校验频道的过滤、分组及台标规则，任意一条规则有误时返回错误。
+// 用于热加载配置，避免有误的规则被跳过后替换掉正在使用的规则
+func (c *Config) CheckRules() error {
+	var errs []error
+	if c.OptionChExcludeRule != "" {
+		if _, err := iptv.ParseChannelRule(c.OptionChExcludeRule); err != nil {
+			errs = append(errs, fmt.Errorf("chExcludeRule %q: %w", c.OptionChExcludeRule, err))
+		}
+	}
+	for _, opChGroupRules := range c.OptionChGroupRulesList {
+		if opChGroupRules.Name == "" || len(opChGroupRules.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("chGroupRules: the name or rules is empty, name: %q", opChGroupRules.Name))
+		}
+		for _, ruleStr := range opChGroupRules.Rules {
+			if _, err := iptv.ParseChannelRule(ruleStr); err != nil {
+				errs = append(errs, fmt.Errorf("chGroupRules %s %q: %w", opChGroupRules.Name, ruleStr, err))
+			}
+		}
+	}
+	for _, opLogoRule := range c.OptionChLogoRuleList {
+		if opLogoRule.Name == "" || opLogoRule.Rule == "" {
+			errs = append(errs, fmt.Errorf("logos: the name or rule is empty, name: %q, rule: %q", opLogoRule.Name, opLogoRule.Rule))
+			continue
+		}
+		if _, err := regexp.Compile(opLogoRule.Rule); err != nil {
+			errs = append(errs, fmt.Errorf("logos %s %q: %w", opLogoRule.Name, opLogoRule.Rule, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseChannelRules 解析视图中的频道规则，忽略错误的规则
+func parseChannelRules(viewName string, ruleStrs []string) []*iptv.ChannelRule {
+	rules := make([]*iptv.ChannelRule, 0, len(ruleStrs))
+	for _, ruleStr := range ruleStrs {
+		rule, err := iptv.ParseChannelRule(ruleStr)
+		if err != nil {
+			zap.L().Warn("The channel view rule is incorrect. Skip it.", zap.String("view", viewName), zap.String("rule", ruleStr), zap.Error(err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// DecodeProviderConfig 将当前平台相关的配置解析到指定的结构体中
+func (c *Config) DecodeProviderConfig(v any) error {
+	node, ok := c.Providers[c.Platform]
+	if !ok {
+		return fmt.Errorf("the config of platform %s is not found", c.Platform)
+	}
+	return node.Decode(v)
+}
+
+// ChannelRules 获取频道的过滤、分组及台标匹配规则，IPTV客户端返回的频道列表需使用其过滤
+func (c *Config) ChannelRules() *iptv.ChannelRules {
+	return &iptv.ChannelRules{
+		ExcludeRule:    c.ChExcludeRule,
+		GroupRulesList: c.ChGroupRulesList,
+		LogoRuleList:   c.ChLogoRuleList,
+	}
+}
+
+// NewIPTVClient 根据配置的平台类型创建IPTV客户端
+func (c *Config) NewIPTVClient(httpClient *http.Client) (iptv.Client, error) {
+	return iptv.NewClient(c.Platform, c.DecodeProviderConfig, &iptv.ClientOptions{
+		HTTPClient:       httpClient,
+		Key:              c.Key,
+		ServerHost:       c.ServerHost,
+		Headers:          c.Headers,
+		ChGroupRulesList: c.ChGroupRulesList,
+		ChLogoRuleList:   c.ChLogoRuleList,
+	})
+}
+
 func Load(fPath string) (*Config, error) {
 	// 读取配置文件
 	data, err := os.ReadFile(fPath)
@@ -159,6 +491,16 @@ func CreateDefaultCfg(fPath string) error {
 	// 创建编码器
 	encoder := yaml.NewEncoder(f)
 
+	// 缺省平台的配置
+	provider, err := iptv.GetProvider(defaultPlatform)
+	if err != nil {
+		return err
+	}
+	var providerNode yaml.Node
+	if err = providerNode.Encode(provider.DefaultConfig()); err != nil {
+		return err
+	}
+
 	// 缺省配置
 	defaultCfg := Config{
 		ServerHost: "127.0.0.1",
@@ -229,7 +571,15 @@ func CreateDefaultCfg(fPath string) error {
 				"1": "playseek={utc:YmdHMS}-{utcend:YmdHMS}",
 			},
 		},
-		HWCTC: &hwctc.Config{},
+		EPG: &EPGConfig{
+			RetentionDays: defaultEPGRetentionDays,
+			Timezone:      defaultEPGTimezone,
+			Lang:          defaultEPGLang,
+		},
+		Platform: defaultPlatform,
+		Providers: map[string]yaml.Node{
+			defaultPlatform: providerNode,
+		},
 	}
 
 	return encoder.Encode(&defaultCfg)
@@ -0,0 +1,220 @@
+package iptv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const catchupTimeLayout = "20060102150405"
+
+var (
+	// 形如 ${(b)yyyyMMddHHmmss} 或 ${(e)yyyyMMddHHmmss} 的占位符，使用本地时间
+	catchupJavaPlaceholder = regexp.MustCompile(`\$\{\((b|e)\)([^}]+)}`)
+	// 形如 {utc:YmdHMS}、{utcend:YmdHMS}、{utc}、{utcend}、{duration}、{lutc} 的占位符，使用UTC时间
+	catchupKodiPlaceholder = regexp.MustCompile(`\{(utc|utcend|duration|lutc)(?::([^}]+))?}`)
+
+	javaLayoutReplacer = strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15", "mm", "04", "ss", "05")
+	kodiLayoutReplacer = strings.NewReplacer("Y", "2006", "m", "01", "d", "02", "H", "15", "M", "04", "S", "05")
+
+	ErrInvalidCatchupTime = errors.New("invalid catchup time range")
+)
+
+// SessionProvider 可选接口，提供访问运营商资源（如回看地址）时所需的会话Cookie
+type SessionProvider interface {
+	SessionCookies(ctx context.Context) ([]*http.Cookie, error)
+}
+
+// RenderCatchupSource 使用指定的起止时间，替换回看请求参数中的占位符
+func RenderCatchupSource(source string, begin, end time.Time) string {
+	result := catchupJavaPlaceholder.ReplaceAllStringFunc(source, func(s string) string {
+		matches := catchupJavaPlaceholder.FindStringSubmatch(s)
+		t := begin
+		if matches[1] == "e" {
+			t = end
+		}
+		return t.Local().Format(javaLayoutReplacer.Replace(matches[2]))
+	})
+
+	return catchupKodiPlaceholder.ReplaceAllStringFunc(result, func(s string) string {
+		matches := catchupKodiPlaceholder.FindStringSubmatch(s)
+		var t time.Time
+		switch matches[1] {
+		case "utc":
+			t = begin
+		case "utcend":
+			t = end
+		case "lutc":
+			t = time.Now()
+		case "duration":
+			return strconv.FormatInt(int64(end.Sub(begin).Seconds()), 10)
+		}
+		if matches[2] == "" {
+			return strconv.FormatInt(t.Unix(), 10)
+		}
+		return t.UTC().Format(kodiLayoutReplacer.Replace(matches[2]))
+	})
+}
+
+// ParseCatchupTimeRange 从回看请求的参数中解析起止时间
+// 优先按回看请求参数的格式source解析：在source中查找包含起止时间占位符的参数（如playseek、starttime、endtime），
+// 并从请求中同名参数的取值里按占位符的格式提取时间，${(b)...}/${(e)...}按本地时间解析，{utc:...}/{utcend:...}按UTC时间解析。
+// 未能解析时，再尝试utc/utcend（Unix时间戳）以及playseek（yyyyMMddHHmmss-yyyyMMddHHmmss）两种通用形式。
+func ParseCatchupTimeRange(query url.Values, source string) (time.Time, time.Time, error) {
+	if begin, end, ok := parseCatchupTimeBySource(query, source); ok {
+		return begin, end, nil
+	}
+
+	if utc := query.Get("utc"); utc != "" {
+		begin, err := strconv.ParseInt(utc, 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidCatchupTime
+		}
+		end := time.Now().Unix()
+		if utcEnd := query.Get("utcend"); utcEnd != "" {
+			if end, err = strconv.ParseInt(utcEnd, 10, 64); err != nil {
+				return time.Time{}, time.Time{}, ErrInvalidCatchupTime
+			}
+		}
+		return time.Unix(begin, 0), time.Unix(end, 0), nil
+	}
+
+	playseek := query.Get("playseek")
+	beginStr, endStr, ok := strings.Cut(playseek, "-")
+	if !ok || len(beginStr) < len(catchupTimeLayout) || len(endStr) < len(catchupTimeLayout) {
+		return time.Time{}, time.Time{}, ErrInvalidCatchupTime
+	}
+
+	loc := time.Local
+	if catchupKodiPlaceholder.MatchString(source) {
+		loc = time.UTC
+	}
+	begin, err := time.ParseInLocation(catchupTimeLayout, beginStr[:len(catchupTimeLayout)], loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidCatchupTime
+	}
+	end, err := time.ParseInLocation(catchupTimeLayout, endStr[:len(catchupTimeLayout)], loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidCatchupTime
+	}
+	return begin, end, nil
+}
+
+// catchupPlaceholder 回看请求参数中的所有占位符
+var catchupPlaceholder = regexp.MustCompile(catchupJavaPlaceholder.String() + "|" + catchupKodiPlaceholder.String())
+
+// parseCatchupTimeBySource 按回看请求参数的格式source，从请求参数中提取起止时间
+func parseCatchupTimeBySource(query url.Values, source string) (time.Time, time.Time, bool) {
+	var begin, end time.Time
+	var duration time.Duration
+	for _, param := range strings.Split(source, "&") {
+		key, tmpl, ok := strings.Cut(param, "=")
+		if !ok || !catchupPlaceholder.MatchString(tmpl) || !query.Has(key) {
+			continue
+		}
+
+		// 将参数的格式转换为正则表达式，各个占位符作为分组
+		var pattern strings.Builder
+		var kinds, layouts []string
+		last := 0
+		for _, loc := range catchupPlaceholder.FindAllStringSubmatchIndex(tmpl, -1) {
+			pattern.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+			last = loc[1]
+
+			var kind, layout string
+			if loc[2] >= 0 {
+				// ${(b)...}、${(e)...}
+				kind, layout = tmpl[loc[2]:loc[3]], javaLayoutReplacer.Replace(tmpl[loc[4]:loc[5]])
+			} else {
+				// {utc}、{utc:...}等，未指定格式时为Unix时间戳
+				kind = tmpl[loc[6]:loc[7]]
+				if loc[8] >= 0 {
+					layout = kodiLayoutReplacer.Replace(tmpl[loc[8]:loc[9]])
+				}
+			}
+			kinds = append(kinds, kind)
+			layouts = append(layouts, layout)
+			if layout == "" {
+				pattern.WriteString(`(\d+)`)
+			} else {
+				pattern.WriteString("(" + layoutPattern(layout) + ")")
+			}
+		}
+		pattern.WriteString(regexp.QuoteMeta(tmpl[last:]))
+
+		re, err := regexp.Compile("^" + pattern.String() + "$")
+		if err != nil {
+			continue
+		}
+		matches := re.FindStringSubmatch(query.Get(key))
+		if matches == nil {
+			return time.Time{}, time.Time{}, false
+		}
+		for i, kind := range kinds {
+			value := matches[i+1]
+			switch kind {
+			case "duration":
+				seconds, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return time.Time{}, time.Time{}, false
+				}
+				duration = time.Duration(seconds) * time.Second
+			case "b", "e", "utc", "utcend":
+				t, err := parseCatchupTime(value, layouts[i], kind == "utc" || kind == "utcend")
+				if err != nil {
+					return time.Time{}, time.Time{}, false
+				}
+				if kind == "b" || kind == "utc" {
+					begin = t
+				} else {
+					end = t
+				}
+			}
+		}
+	}
+
+	if begin.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	if end.IsZero() {
+		if duration <= 0 {
+			return time.Time{}, time.Time{}, false
+		}
+		end = begin.Add(duration)
+	}
+	return begin, end, true
+}
+
+// layoutPattern 将时间格式转换为正则表达式，数字替换为\d，其他字符原样匹配
+func layoutPattern(layout string) string {
+	var pattern strings.Builder
+	for _, r := range layout {
+		if r >= '0' && r <= '9' {
+			pattern.WriteString(`\d`)
+		} else {
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return pattern.String()
+}
+
+// parseCatchupTime 按格式解析时间，layout为空时为Unix时间戳，utc为true时按UTC时间解析，否则按本地时间解析
+func parseCatchupTime(value, layout string, utc bool) (time.Time, error) {
+	if layout == "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	loc := time.Local
+	if utc {
+		loc = time.UTC
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
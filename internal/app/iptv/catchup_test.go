@@ -0,0 +1,101 @@
+package iptv_test
+
+import (
+	"iptv/internal/app/iptv"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseCatchupTimeRange(t *testing.T) {
+	localBegin := time.Date(2024, 11, 22, 10, 0, 0, 0, time.Local)
+	localEnd := time.Date(2024, 11, 22, 11, 0, 0, 0, time.Local)
+	utcBegin := time.Date(2024, 11, 22, 2, 0, 0, 0, time.UTC)
+	utcEnd := time.Date(2024, 11, 22, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		source    string
+		query     string
+		wantBegin time.Time
+		wantEnd   time.Time
+		wantErr   bool
+	}{
+		{
+			name:      "playseek",
+			source:    "playseek=${(b)yyyyMMddHHmmss}-${(e)yyyyMMddHHmmss}",
+			query:     "playseek=20241122100000-20241122110000",
+			wantBegin: localBegin,
+			wantEnd:   localEnd,
+		},
+		{
+			name:      "playseek utc",
+			source:    "playseek={utc:YmdHMS}-{utcend:YmdHMS}",
+			query:     "playseek=20241122020000-20241122030000",
+			wantBegin: utcBegin,
+			wantEnd:   utcEnd,
+		},
+		{
+			name:      "custom keys",
+			source:    "starttime=${(b)yyyyMMddHHmmss}&endtime=${(e)yyyyMMddHHmmss}",
+			query:     "csFormat=2&starttime=20241122100000&endtime=20241122110000",
+			wantBegin: localBegin,
+			wantEnd:   localEnd,
+		},
+		{
+			name:      "custom layout",
+			source:    "tvshift=${(b)yyyy-MM-dd HH:mm:ss}~${(e)yyyy-MM-dd HH:mm:ss}",
+			query:     "tvshift=2024-11-22+10:00:00~2024-11-22+11:00:00",
+			wantBegin: localBegin,
+			wantEnd:   localEnd,
+		},
+		{
+			name:      "unix timestamp and duration",
+			source:    "starttime={utc}&duration={duration}",
+			query:     "starttime=1732240800&duration=3600",
+			wantBegin: utcBegin,
+			wantEnd:   utcEnd,
+		},
+		{
+			name:      "utc query",
+			source:    "starttime=${(b)yyyyMMddHHmmss}&endtime=${(e)yyyyMMddHHmmss}",
+			query:     "utc=1732240800&utcend=1732244400",
+			wantBegin: utcBegin,
+			wantEnd:   utcEnd,
+		},
+		{
+			name:    "mismatched value",
+			source:  "starttime=${(b)yyyyMMddHHmmss}&endtime=${(e)yyyyMMddHHmmss}",
+			query:   "starttime=2024112210&endtime=20241122110000",
+			wantErr: true,
+		},
+		{
+			name:    "missing",
+			source:  "starttime=${(b)yyyyMMddHHmmss}&endtime=${(e)yyyyMMddHHmmss}",
+			query:   "csFormat=2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			begin, end, err := iptv.ParseCatchupTimeRange(query, tt.source)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseCatchupTimeRange() = %v, %v, want error", begin, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCatchupTimeRange() error = %v", err)
+			}
+			if !begin.Equal(tt.wantBegin) || !end.Equal(tt.wantEnd) {
+				t.Errorf("ParseCatchupTimeRange() = %v, %v, want %v, %v", begin, end, tt.wantBegin, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -3,16 +3,43 @@ package iptv
 import (
 	"errors"
 	"fmt"
-	"iptv/internal/pkg/util"
 	"net/url"
-	"os"
-	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
 const SCHEME_IGMP = "igmp"
 
+// 直播源中频道URL地址的输出方式
+const (
+	URLModeSingle = "single" // 每个频道只输出一个URL地址（m3u中合并的其他清晰度版本仍作为备用地址输出）
+	URLModeAll    = "all"    // 输出频道的所有URL地址，每个地址单独一行（条目）
+	URLModeHash   = "hash"   // 仅txt格式，所有URL地址使用#连接后在同一行输出，其他格式同all
+)
+
+// IsValidURLMode 判断URL地址的输出方式是否有效，空字符串同single
+func IsValidURLMode(urlMode string) bool {
+	switch urlMode {
+	case "", URLModeSingle, URLModeAll, URLModeHash:
+		return true
+	default:
+		return false
+	}
+}
+
+// isAllURLMode 是否输出频道的所有URL地址
+func isAllURLMode(urlMode string) bool {
+	return urlMode == URLModeAll || urlMode == URLModeHash
+}
+
+// 频道的清晰度
+const (
+	DefinitionSD  = "SD"
+	DefinitionHD  = "HD"
+	DefinitionUHD = "4K"
+)
+
 // Channel 频道信息
 type Channel struct {
 	ChannelID       string        `json:"channelID"`       // 频道ID
@@ -23,28 +50,35 @@ type Channel struct {
 	TimeShiftLength time.Duration `json:"timeShiftLength"` // 支持的时移长度
 	TimeShiftURL    *url.URL      `json:"timeShiftURL"`    // 时移地址（回放地址）
 
+	Definition string `json:"definition,omitempty"` // 清晰度：SD、HD、4K
+	FCCEnable  bool   `json:"fccEnable,omitempty"`  // 是否支持FCC快速换台
+	FCCServer  string `json:"fccServer,omitempty"`  // FCC服务器的地址和端口
+	ChannelSDP string `json:"channelSDP,omitempty"` // 频道的SDP信息
+
+	BackupURLs       [][]url.URL `json:"backupURLs,omitempty"`       // 合并的其他清晰度版本的频道URL列表，作为备用地址
+	MergedChannelIDs []string    `json:"mergedChannelIDs,omitempty"` // 合并的其他清晰度版本的频道ID，与BackupURLs一一对应
+
 	GroupName string `json:"groupName"` // 程序识别的频道分类
 	LogoName  string `json:"logoName"`  // 频道台标名称
+
+	Properties map[string]string `json:"properties,omitempty"` // 运营商返回的其他频道字段，如ChannelSDP、FCCEnable、IsHDChannel等
 }
 
 // ToM3UFormat 转换为M3U格式内容
-func ToM3UFormat(channels []Channel, udpxyURL, catchupSource string, multicastFirst bool, logoBaseUrl string) (string, error) {
+// catchupBaseURL不为空时，回看地址将通过本服务进行代理，格式为：{catchupBaseURL}/{ChannelID}?{catchupSource}
+// 频道存在多个URL地址时，每个地址输出为一个使用相同tvg-id的条目
+func ToM3UFormat(channels []Channel, udpxyURL, catchupSource string, multicastFirst bool, urlMode, logoBaseUrl, catchupBaseURL string) (string, error) {
 	if len(channels) == 0 {
 		return "", errors.New("no channels found")
 	}
 
 	catchupSource = strings.TrimLeft(catchupSource, "?&")
 
-	currDir, err := util.GetCurrentAbPathByExecutable()
-	if err != nil {
-		return "", err
-	}
-
 	var sb strings.Builder
 	sb.WriteString("#EXTM3U\n")
 	for _, channel := range channels {
 		// 根据指定条件，获取频道URL地址
-		channelURLStr, isMulticastCh, err := getChannelURLStr(channel.ChannelURLs, udpxyURL, multicastFirst)
+		channelURLStrs, isMulticastCh, err := getChannelURLStrs(&channel, udpxyURL, multicastFirst, isAllURLMode(urlMode))
 		if err != nil {
 			return "", err
 		}
@@ -55,20 +89,19 @@ func ToM3UFormat(channels []Channel, udpxyURL, catchupSource string, multicastFi
 		m3uLineSb.WriteString(fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-chno=\"%s\"",
 			channel.ChannelID, channel.UserChannelID))
 		// 设置频道的台标URL
-		if logoBaseUrl != "" && channel.LogoName != "" {
-			logoFile := channel.LogoName + ".png"
-			if _, err = os.Stat(filepath.Join(currDir, logoDirName, logoFile)); !os.IsNotExist(err) {
-				if logoUrl, err := url.JoinPath(logoBaseUrl, logoFile); err == nil {
-					m3uLineSb.WriteString(fmt.Sprintf(" tvg-logo=\"%s\"",
-						logoUrl))
-				}
-			}
+		if logoUrl := GetChannelLogoURL(logoBaseUrl, channel.LogoName); logoUrl != "" {
+			m3uLineSb.WriteString(fmt.Sprintf(" tvg-logo=\"%s\"",
+				logoUrl))
 		}
 		// 设置频道回看参数
 		if catchupSource != "" &&
 			channel.TimeShift == "1" && channel.TimeShiftLength > 0 && channel.TimeShiftURL != nil {
 			var chCatchup, chCatchupSource string
-			if isMulticastCh {
+			if catchupBaseURL != "" {
+				// 通过本服务代理回看请求，不暴露运营商的回看地址
+				chCatchup = "default"
+				chCatchupSource = catchupBaseURL + "/" + url.PathEscape(channel.ChannelID) + "?" + catchupSource
+			} else if isMulticastCh {
 				chCatchup = "default"
 				chCatchupSource = channel.TimeShiftURL.String()
 				if channel.TimeShiftURL.RawQuery != "" {
@@ -84,16 +117,24 @@ func ToM3UFormat(channels []Channel, udpxyURL, catchupSource string, multicastFi
 			m3uLineSb.WriteString(fmt.Sprintf(" catchup=\"%s\" catchup-source=\"%s\" catchup-days=\"%d\"",
 				chCatchup, chCatchupSource, int64(channel.TimeShiftLength.Hours()/24)))
 		}
+		// 设置频道的清晰度
+		if channel.Definition != "" {
+			m3uLineSb.WriteString(fmt.Sprintf(" resolution=\"%s\"", channel.Definition))
+		}
 		// 设置频道分组和名称
-		m3uLineSb.WriteString(fmt.Sprintf(" group-title=\"%s\",%s\n%s\n",
-			channel.GroupName, channel.ChannelName, channelURLStr))
-		sb.WriteString(m3uLineSb.String())
+		m3uLineSb.WriteString(fmt.Sprintf(" group-title=\"%s\",%s\n",
+			channel.GroupName, channel.ChannelName))
+		extInfLine := m3uLineSb.String()
+		for _, channelURLStr := range channelURLStrs {
+			sb.WriteString(extInfLine)
+			sb.WriteString(channelURLStr + "\n")
+		}
 	}
 	return sb.String(), nil
 }
 
 // ToTxtFormat 转换为txt格式内容
-func ToTxtFormat(channels []Channel, udpxyURL string, multicastFirst bool) (string, error) {
+func ToTxtFormat(channels []Channel, udpxyURL string, multicastFirst bool, urlMode string) (string, error) {
 	if len(channels) == 0 {
 		return "", errors.New("no channels found")
 	}
@@ -125,63 +166,113 @@ func ToTxtFormat(channels []Channel, udpxyURL string, multicastFirst bool) (stri
 		// 输出频道信息
 		for _, channel := range groupChannels {
 			// 根据指定条件，获取频道URL地址
-			channelURLStr, _, err := getChannelURLStr(channel.ChannelURLs, udpxyURL, multicastFirst)
+			channelURLStrs, _, err := getChannelURLStrs(&channel, udpxyURL, multicastFirst, isAllURLMode(urlMode))
 			if err != nil {
 				return "", err
 			}
 
-			txtLine := fmt.Sprintf("%s,%s\n",
-				channel.ChannelName, channelURLStr)
-			sb.WriteString(txtLine)
+			switch urlMode {
+			case URLModeAll:
+				// 重复输出频道名称，每行一个地址
+				for _, channelURLStr := range channelURLStrs {
+					sb.WriteString(fmt.Sprintf("%s,%s\n", channel.ChannelName, channelURLStr))
+				}
+			case URLModeHash:
+				// 所有地址使用#连接
+				sb.WriteString(fmt.Sprintf("%s,%s\n", channel.ChannelName, strings.Join(channelURLStrs, "#")))
+			default:
+				sb.WriteString(fmt.Sprintf("%s,%s\n", channel.ChannelName, channelURLStrs[0]))
+			}
 		}
 	}
 	return sb.String(), nil
 }
 
 // ToPLSFormat 转换为pls(playlist)格式内容
-func ToPLSFormat(channels []Channel, udpxyURL string, multicastFirst bool) (string, error) {
+func ToPLSFormat(channels []Channel, udpxyURL string, multicastFirst bool, urlMode string) (string, error) {
 	if len(channels) == 0 {
 		return "", errors.New("no channels found")
 	}
 
+	allURLs := isAllURLMode(urlMode)
+
 	var sb strings.Builder
 	sb.WriteString("[playlist]\n\n")
-	for i, channel := range channels {
+	var entryIndex int
+	for _, channel := range channels {
 		// 根据指定条件，获取频道URL地址
-		channelURLStr, _, err := getChannelURLStr(channel.ChannelURLs, udpxyURL, multicastFirst)
+		channelURLStrs, _, err := getChannelURLStrs(&channel, udpxyURL, multicastFirst, allURLs)
 		if err != nil {
 			return "", err
 		}
+		if !allURLs {
+			channelURLStrs = channelURLStrs[:1]
+		}
 
-		entryIndex := i + 1
-		// 设置频道URL
-		sb.WriteString(fmt.Sprintf("File%d=%s\n", entryIndex, channelURLStr))
-		// 设置频道名称
-		sb.WriteString(fmt.Sprintf("Title%d=%s\n\n", entryIndex, channel.ChannelName))
+		for _, channelURLStr := range channelURLStrs {
+			entryIndex++
+			// 设置频道URL
+			sb.WriteString(fmt.Sprintf("File%d=%s\n", entryIndex, channelURLStr))
+			// 设置频道名称
+			sb.WriteString(fmt.Sprintf("Title%d=%s\n\n", entryIndex, channel.ChannelName))
+		}
 	}
-	sb.WriteString(fmt.Sprintf("NumberOfEntries=%d\n", len(channels)))
+	sb.WriteString(fmt.Sprintf("NumberOfEntries=%d\n", entryIndex))
 	sb.WriteString("Version=2\n")
 	return sb.String(), nil
 }
 
-// getChannelURLStr 根据指定条件，获取频道URL地址
-func getChannelURLStr(channelURLs []url.URL, udpxyURL string, multicastFirst bool) (string, bool, error) {
-	if len(channelURLs) == 0 {
-		return "", false, errors.New("no channel urls found")
-	}
+// getChannelURLStrs 根据指定条件，获取频道及其备用地址（合并的其他清晰度版本）的URL地址列表
+// allURLs为false时，每个版本只取一个优先的地址；为true时取所有地址，优先的地址排在前面。
+// 返回的第二个值表示第一个地址是否为组播地址
+func getChannelURLStrs(channel *Channel, udpxyURL string, multicastFirst, allURLs bool) ([]string, bool, error) {
+	var result []string
+	var isMulticastCh bool
+	for i, channelURLs := range append([][]url.URL{channel.ChannelURLs}, channel.BackupURLs...) {
+		sortedURLs := sortChannelURLs(channelURLs, multicastFirst)
+		if len(sortedURLs) == 0 {
+			if i == 0 {
+				return nil, false, errors.New("no channel urls found")
+			}
+			continue
+		}
+		if !allURLs {
+			sortedURLs = sortedURLs[:1]
+		}
 
-	var channelURL url.URL
-	if len(channelURLs) == 1 {
-		channelURL = channelURLs[0]
-	} else {
-		for _, channelURL = range channelURLs {
-			if (multicastFirst && channelURL.Scheme == SCHEME_IGMP) ||
-				(!multicastFirst && channelURL.Scheme != SCHEME_IGMP) {
-				break
+		for j, channelURL := range sortedURLs {
+			channelURLStr, isMulticast, err := getChannelURLStr(channelURL, udpxyURL)
+			if err != nil {
+				return nil, false, err
+			}
+			if i == 0 && j == 0 {
+				isMulticastCh = isMulticast
 			}
+			result = append(result, channelURLStr)
 		}
 	}
+	return result, isMulticastCh, nil
+}
+
+// sortChannelURLs 根据是否优先使用组播地址，对频道的URL地址进行排序
+func sortChannelURLs(channelURLs []url.URL, multicastFirst bool) []url.URL {
+	sortedURLs := slices.Clone(channelURLs)
+	slices.SortStableFunc(sortedURLs, func(a, b url.URL) int {
+		return urlPriority(a, multicastFirst) - urlPriority(b, multicastFirst)
+	})
+	return sortedURLs
+}
+
+// urlPriority 获取URL地址的优先级，越小越优先
+func urlPriority(channelURL url.URL, multicastFirst bool) int {
+	if (channelURL.Scheme == SCHEME_IGMP) == multicastFirst {
+		return 0
+	}
+	return 1
+}
 
+// getChannelURLStr 获取频道URL地址，组播地址在配置了udpxy时转换为udpxy的地址
+func getChannelURLStr(channelURL url.URL, udpxyURL string) (string, bool, error) {
 	isMulticastCh := channelURL.Scheme == SCHEME_IGMP
 	if udpxyURL != "" && isMulticastCh {
 		result, err := url.JoinPath(udpxyURL, fmt.Sprintf("/rtp/%s", channelURL.Host))
@@ -0,0 +1,119 @@
+package iptv
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// ChannelRenameRule 频道名称的替换规则
+type ChannelRenameRule struct {
+	Name string         // 替换后的名称，支持$G1, $G2等分组引用
+	Rule *regexp.Regexp // 频道名称的匹配规则
+}
+
+// ChannelPin 将频道固定在指定的位置
+type ChannelPin struct {
+	Channel  string // 频道ID或频道名称（重命名后）
+	Position int    // 从1开始的位置
+}
+
+// ChannelCustomizer 对频道列表进行重命名、修改频道号以及排序等自定义处理
+type ChannelCustomizer struct {
+	RenameRules []ChannelRenameRule // 频道名称的替换规则，按顺序匹配第一条
+	Numbers     map[string]string   // 频道ID或频道名称（重命名后）对应的频道号
+	Pins        []ChannelPin        // 固定位置的频道
+	GroupOrder  []string            // 分组的排列顺序，未列出的分组排在最后
+}
+
+// Apply 返回自定义处理后的频道列表，不修改原有的频道列表
+func (c *ChannelCustomizer) Apply(channels []Channel) []Channel {
+	result := slices.Clone(channels)
+	if c == nil {
+		return result
+	}
+
+	// 重命名频道，并修改频道号
+	for i := range result {
+		channel := &result[i]
+		channel.ChannelName = c.resolveName(channel.ChannelName)
+		if number, ok := c.lookupNumber(channel); ok {
+			channel.UserChannelID = number
+		}
+	}
+
+	// 按分组的排列顺序排序，同一分组内保持原有顺序
+	if len(c.GroupOrder) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return c.groupRank(result[i].GroupName) < c.groupRank(result[j].GroupName)
+		})
+	}
+
+	// 将频道固定在指定的位置
+	if len(c.Pins) > 0 {
+		result = c.applyPins(result)
+	}
+	return result
+}
+
+// resolveName 根据替换规则获取频道名称
+func (c *ChannelCustomizer) resolveName(channelName string) string {
+	for _, renameRule := range c.RenameRules {
+		matches := renameRule.Rule.FindStringSubmatch(channelName)
+		if len(matches) > 0 {
+			return expandGroups(renameRule.Name, matches)
+		}
+	}
+	return channelName
+}
+
+// lookupNumber 查询频道自定义的频道号，优先按频道ID匹配
+func (c *ChannelCustomizer) lookupNumber(channel *Channel) (string, bool) {
+	if number, ok := c.Numbers[channel.ChannelID]; ok {
+		return number, true
+	}
+	number, ok := c.Numbers[channel.ChannelName]
+	return number, ok
+}
+
+// groupRank 获取分组的排列序号
+func (c *ChannelCustomizer) groupRank(groupName string) int {
+	if i := slices.Index(c.GroupOrder, groupName); i >= 0 {
+		return i
+	}
+	return len(c.GroupOrder)
+}
+
+// applyPins 将固定位置的频道移动到指定位置，超出范围时放在最后
+func (c *ChannelCustomizer) applyPins(channels []Channel) []Channel {
+	pins := slices.Clone(c.Pins)
+	sort.SliceStable(pins, func(i, j int) bool {
+		return pins[i].Position < pins[j].Position
+	})
+
+	// 先取出所有固定位置的频道
+	pinned := make([]Channel, len(pins))
+	found := make([]bool, len(pins))
+	rest := make([]Channel, 0, len(channels))
+	for _, channel := range channels {
+		idx := slices.IndexFunc(pins, func(pin ChannelPin) bool {
+			return pin.Channel == channel.ChannelID || pin.Channel == channel.ChannelName
+		})
+		if idx < 0 || found[idx] {
+			rest = append(rest, channel)
+			continue
+		}
+		pinned[idx] = channel
+		found[idx] = true
+	}
+
+	// 按位置从小到大依次插入
+	for i, pin := range pins {
+		if !found[i] {
+			continue
+		}
+		pos := min(max(pin.Position-1, 0), len(rest))
+		rest = slices.Insert(rest, pos, pinned[i])
+	}
+	return rest
+}
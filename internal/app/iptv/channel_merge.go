@@ -0,0 +1,80 @@
+package iptv
+
+import (
+	"net/url"
+	"slices"
+)
+
+// 缺省的清晰度优先级，靠前的优先
+var defaultDefinitionPriority = []string{DefinitionUHD, DefinitionHD, DefinitionSD}
+
+// ChannelMerger 将同一频道的不同清晰度版本合并为一个频道
+type ChannelMerger struct {
+	KeyRules []ChannelLogoRule // 识别同一频道的规则，转换后名称相同的频道将被合并，为空时使用频道台标名称
+	Priority []string          // 清晰度的优先级，靠前的优先，为空时使用缺省的优先级
+}
+
+// Merge 合并同一频道的不同清晰度版本：保留优先级最高的频道，其他版本的URL作为备用地址，并记录其他版本的频道ID
+func (m *ChannelMerger) Merge(channels []Channel) []Channel {
+	if m == nil {
+		return channels
+	}
+
+	// 按合并的key对频道进行分组，分组的顺序为其中第一个频道的位置
+	keys := make([]string, 0, len(channels))
+	keyChannelsMap := make(map[string][]Channel)
+	for _, channel := range channels {
+		key := m.mergeKey(&channel)
+		if _, ok := keyChannelsMap[key]; !ok {
+			keys = append(keys, key)
+		}
+		keyChannelsMap[key] = append(keyChannelsMap[key], channel)
+	}
+
+	result := make([]Channel, 0, len(keys))
+	for _, key := range keys {
+		variants := keyChannelsMap[key]
+		if len(variants) == 1 {
+			result = append(result, variants[0])
+			continue
+		}
+
+		// 按清晰度的优先级排序，相同清晰度保持原有顺序
+		slices.SortStableFunc(variants, func(a, b Channel) int {
+			return m.rank(a.Definition) - m.rank(b.Definition)
+		})
+
+		merged := variants[0]
+		merged.BackupURLs = make([][]url.URL, 0, len(variants)-1)
+		merged.MergedChannelIDs = make([]string, 0, len(variants)-1)
+		for _, variant := range variants[1:] {
+			merged.BackupURLs = append(merged.BackupURLs, variant.ChannelURLs)
+			merged.MergedChannelIDs = append(merged.MergedChannelIDs, variant.ChannelID)
+		}
+		result = append(result, merged)
+	}
+	return result
+}
+
+// mergeKey 获取用于识别同一频道的key
+func (m *ChannelMerger) mergeKey(channel *Channel) string {
+	if len(m.KeyRules) > 0 {
+		return GetChannelLogoName(m.KeyRules, channel.ChannelName)
+	}
+	if channel.LogoName != "" {
+		return channel.LogoName
+	}
+	return channel.ChannelName
+}
+
+// rank 获取清晰度的优先级序号，越小越优先
+func (m *ChannelMerger) rank(definition string) int {
+	priority := m.Priority
+	if len(priority) == 0 {
+		priority = defaultDefinitionPriority
+	}
+	if i := slices.Index(priority, definition); i >= 0 {
+		return i
+	}
+	return len(priority)
+}
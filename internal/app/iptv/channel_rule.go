@@ -0,0 +1,88 @@
+package iptv
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// 频道规则可匹配的字段
+const (
+	ChRuleFieldName       = "name"       // 频道名称
+	ChRuleFieldID         = "id"         // 频道ID
+	ChRuleFieldNumber     = "number"     // 频道号
+	ChRuleFieldDefinition = "definition" // 清晰度：SD、HD、4K
+	ChRuleFieldFCC        = "fcc"        // 是否支持FCC快速换台：1、0
+	ChRuleFieldSDP        = "sdp"        // 频道的SDP信息
+	ChRuleFieldGroup      = "group"      // 频道分组
+	ChRuleFieldMulticast  = "multicast"  // 是否有组播地址：1、0
+	ChRuleFieldCatchup    = "catchup"    // 是否支持回看：1、0
+)
+
+var chRuleFields = []string{ChRuleFieldName, ChRuleFieldID, ChRuleFieldNumber, ChRuleFieldDefinition, ChRuleFieldFCC, ChRuleFieldSDP,
+	ChRuleFieldGroup, ChRuleFieldMulticast, ChRuleFieldCatchup}
+
+// ChannelRule 频道的匹配规则，用于频道过滤和分组
+type ChannelRule struct {
+	Field string         // 匹配的频道字段，缺省为频道名称
+	Rule  *regexp.Regexp // 匹配规则
+}
+
+// ParseChannelRule 解析频道的匹配规则
+// 规则缺省匹配频道名称，也可使用“字段:正则表达式”的形式匹配频道的其他字段，如：definition:^4K$
+func ParseChannelRule(s string) (*ChannelRule, error) {
+	field := ChRuleFieldName
+	if prefix, rest, ok := strings.Cut(s, ":"); ok {
+		for _, f := range chRuleFields {
+			if prefix == f {
+				field, s = f, rest
+				break
+			}
+		}
+	}
+
+	rule, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelRule{
+		Field: field,
+		Rule:  rule,
+	}, nil
+}
+
+// Match 判断频道是否匹配该规则
+func (r *ChannelRule) Match(channel *Channel) bool {
+	var value string
+	switch r.Field {
+	case ChRuleFieldID:
+		value = channel.ChannelID
+	case ChRuleFieldNumber:
+		value = channel.UserChannelID
+	case ChRuleFieldDefinition:
+		value = channel.Definition
+	case ChRuleFieldFCC:
+		value = boolRuleValue(channel.FCCEnable)
+	case ChRuleFieldSDP:
+		value = channel.ChannelSDP
+	case ChRuleFieldGroup:
+		value = channel.GroupName
+	case ChRuleFieldMulticast:
+		value = boolRuleValue(slices.ContainsFunc(channel.ChannelURLs, func(u url.URL) bool {
+			return u.Scheme == SCHEME_IGMP
+		}))
+	case ChRuleFieldCatchup:
+		value = boolRuleValue(channel.TimeShift == "1" && channel.TimeShiftLength > 0 && channel.TimeShiftURL != nil)
+	default:
+		value = channel.ChannelName
+	}
+	return r.Rule.MatchString(value)
+}
+
+func boolRuleValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,40 @@
+package iptv
+
+// ChannelRules 频道的过滤、分组及台标匹配规则，支持在运行时替换
+type ChannelRules struct {
+	ExcludeRule    *ChannelRule        // 频道的过滤规则
+	GroupRulesList []ChannelGroupRules // 频道分组的规则
+	LogoRuleList   []ChannelLogoRule   // 频道台标的匹配规则
+}
+
+// ChannelRulesUpdater 可选接口，支持在运行时替换获取频道列表时使用的分组及台标规则。
+// IPTV客户端返回全部频道，由调用方使用Apply按过滤规则过滤，以便修改规则后重新过滤
+type ChannelRulesUpdater interface {
+	UpdateChannelRules(rules *ChannelRules)
+}
+
+// Excluded 判断频道是否需要过滤掉，过滤时不使用频道已识别的分组
+func (r *ChannelRules) Excluded(channel *Channel) bool {
+	if r == nil || r.ExcludeRule == nil {
+		return false
+	}
+	ungrouped := *channel
+	ungrouped.GroupName = ""
+	return r.ExcludeRule.Match(&ungrouped)
+}
+
+// Apply 使用规则重新过滤频道，并重新识别频道的分组及台标，不修改原有的频道列表
+func (r *ChannelRules) Apply(channels []Channel) []Channel {
+	result := make([]Channel, 0, len(channels))
+	for _, channel := range channels {
+		if r.Excluded(&channel) {
+			continue
+		}
+		if r != nil {
+			channel.GroupName = GetChannelGroupName(r.GroupRulesList, &channel)
+			channel.LogoName = GetChannelLogoName(r.LogoRuleList, channel.ChannelName)
+		}
+		result = append(result, channel)
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package iptv
+
+// ChannelView 频道列表的视图，按规则筛选出部分频道
+type ChannelView struct {
+	Include []*ChannelRule // 包含规则，匹配任意一条即包含，为空时包含所有频道
+	Exclude []*ChannelRule // 排除规则，匹配任意一条即排除
+}
+
+// Filter 筛选出符合视图规则的频道
+func (v *ChannelView) Filter(channels []Channel) []Channel {
+	result := make([]Channel, 0, len(channels))
+	for i := range channels {
+		if v.match(&channels[i]) {
+			result = append(result, channels[i])
+		}
+	}
+	return result
+}
+
+// match 判断频道是否属于该视图
+func (v *ChannelView) match(channel *Channel) bool {
+	for _, rule := range v.Exclude {
+		if rule.Match(channel) {
+			return false
+		}
+	}
+	if len(v.Include) == 0 {
+		return true
+	}
+	for _, rule := range v.Include {
+		if rule.Match(channel) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package iptv
 
 import (
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -24,4 +26,161 @@ type Program struct {
 	EndTimeFormat   string `json:"endTimeFormat"`   // 格式化的结束时间，例如：20241122210100
 	StartTime       string `json:"startTime"`       // 开始时间，例如：20:57
 	EndTime         string `json:"endTime"`         // 结束时间，例如：21:01
+
+	// 以下为可选信息，取决于节目单接口是否返回
+	Desc       string `json:"desc,omitempty"`       // 节目描述
+	Category   string `json:"category,omitempty"`   // 节目类型
+	EpisodeNum string `json:"episodeNum,omitempty"` // 集数，例如：第6集
+	Rating     string `json:"rating,omitempty"`     // 节目分级
+}
+
+// MergeChannelProgramLists 将新获取的节目单合并到已有的节目单中
+// 按频道和日期进行合并：新数据为空的日期保留原有数据；新旧节目重叠时以新数据为准；早于retainFrom的日期将被丢弃。
+func MergeChannelProgramLists(oldLists, newLists []ChannelProgramList, retainFrom time.Time) []ChannelProgramList {
+	oldListMap := make(map[string]*ChannelProgramList, len(oldLists))
+	for i := range oldLists {
+		oldListMap[oldLists[i].ChannelId] = &oldLists[i]
+	}
+
+	result := make([]ChannelProgramList, 0, max(len(oldLists), len(newLists)))
+	merged := make(map[string]struct{}, len(newLists))
+	for _, newList := range newLists {
+		merged[newList.ChannelId] = struct{}{}
+
+		var oldDateProgramList []DateProgram
+		if oldList, ok := oldListMap[newList.ChannelId]; ok {
+			oldDateProgramList = oldList.DateProgramList
+		}
+		dateProgramList := mergeDateProgramLists(oldDateProgramList, newList.DateProgramList, retainFrom)
+		if len(dateProgramList) == 0 {
+			continue
+		}
+
+		result = append(result, ChannelProgramList{
+			ChannelId:       newList.ChannelId,
+			ChannelName:     newList.ChannelName,
+			DateProgramList: dateProgramList,
+		})
+	}
+
+	// 保留本次未获取到节目单的频道的历史数据
+	for _, oldList := range oldLists {
+		if _, ok := merged[oldList.ChannelId]; ok {
+			continue
+		}
+
+		dateProgramList := mergeDateProgramLists(oldList.DateProgramList, nil, retainFrom)
+		if len(dateProgramList) == 0 {
+			continue
+		}
+
+		result = append(result, ChannelProgramList{
+			ChannelId:       oldList.ChannelId,
+			ChannelName:     oldList.ChannelName,
+			DateProgramList: dateProgramList,
+		})
+	}
+	return result
+}
+
+// mergeDateProgramLists 合并同一频道不同日期的节目单，结果按日期升序排序
+func mergeDateProgramLists(oldList, newList []DateProgram, retainFrom time.Time) []DateProgram {
+	dateMap := make(map[string]DateProgram, len(oldList)+len(newList))
+	for _, dateProg := range oldList {
+		if dateProg.Date.Before(retainFrom) || len(dateProg.ProgramList) == 0 {
+			continue
+		}
+		dateMap[dateProg.Date.Format("20060102")] = dateProg
+	}
+	for _, dateProg := range newList {
+		// 新数据为空时不覆盖原有数据
+		if dateProg.Date.Before(retainFrom) || len(dateProg.ProgramList) == 0 {
+			continue
+		}
+
+		dateStr := dateProg.Date.Format("20060102")
+		if oldDateProg, ok := dateMap[dateStr]; ok {
+			dateProg.ProgramList = mergeProgramLists(oldDateProg.ProgramList, dateProg.ProgramList)
+		}
+		dateMap[dateStr] = dateProg
+	}
+
+	result := make([]DateProgram, 0, len(dateMap))
+	for _, dateProg := range dateMap {
+		result = append(result, dateProg)
+	}
+	slices.SortFunc(result, func(a, b DateProgram) int {
+		return a.Date.Compare(b.Date)
+	})
+	return result
+}
+
+// mergeProgramLists 合并同一天的节目，以新数据为准，仅保留与新节目时间不重叠的旧节目，结果按开始时间排序
+func mergeProgramLists(oldList, newList []Program) []Program {
+	result := make([]Program, 0, len(oldList)+len(newList))
+	result = append(result, newList...)
+	for _, oldProg := range oldList {
+		overlapped := slices.ContainsFunc(newList, func(newProg Program) bool {
+			// 开始时间相同，或者时间段存在交叉
+			return oldProg.BeginTimeFormat == newProg.BeginTimeFormat ||
+				(oldProg.BeginTimeFormat < newProg.EndTimeFormat && newProg.BeginTimeFormat < oldProg.EndTimeFormat)
+		})
+		if !overlapped {
+			result = append(result, oldProg)
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b Program) int {
+		return strings.Compare(a.BeginTimeFormat, b.BeginTimeFormat)
+	})
+	return slices.CompactFunc(result, func(a, b Program) bool {
+		return a.BeginTimeFormat == b.BeginTimeFormat
+	})
+}
+
+// NowNext 获取指定时间正在播放的节目及下一个节目，没有对应的节目时返回nil
+// 跨零点的节目可能出现在前一天的节目单中，或结束时间被错误地设置为当天零点，因此合并前后三天的节目后再查找。
+func (l *ChannelProgramList) NowNext(now time.Time) (*Program, *Program) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	programs := make([]Program, 0)
+	for _, dateProg := range l.DateProgramList {
+		if dateProg.Date.Equal(day.AddDate(0, 0, -1)) || dateProg.Date.Equal(day) || dateProg.Date.Equal(day.AddDate(0, 0, 1)) {
+			programs = append(programs, dateProg.ProgramList...)
+		}
+	}
+	if len(programs) == 0 {
+		return nil, nil
+	}
+	slices.SortStableFunc(programs, func(a, b Program) int {
+		return strings.Compare(a.BeginTimeFormat, b.BeginTimeFormat)
+	})
+	programs = slices.CompactFunc(programs, func(a, b Program) bool {
+		return a.BeginTimeFormat == b.BeginTimeFormat
+	})
+
+	nowStr := now.Format("20060102150405")
+	for i := range programs {
+		program := &programs[i]
+		if program.BeginTimeFormat > nowStr {
+			// 当前时间没有节目，只返回下一个节目
+			return nil, program
+		}
+
+		// 结束时间早于开始时间时，以下一个节目的开始时间或第二天零点作为结束时间
+		endTimeFormat := program.EndTimeFormat
+		if endTimeFormat <= program.BeginTimeFormat {
+			if i+1 < len(programs) {
+				endTimeFormat = programs[i+1].BeginTimeFormat
+			} else if beginTime, err := time.ParseInLocation("20060102150405", program.BeginTimeFormat, now.Location()); err == nil {
+				endTimeFormat = time.Date(beginTime.Year(), beginTime.Month(), beginTime.Day()+1, 0, 0, 0, 0, beginTime.Location()).Format("20060102150405")
+			}
+		}
+		if nowStr < endTimeFormat {
+			if i+1 < len(programs) {
+				return program, &programs[i+1]
+			}
+			return program, nil
+		}
+	}
+	return nil, nil
 }
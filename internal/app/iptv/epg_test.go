@@ -0,0 +1,237 @@
+package iptv_test
+
+import (
+	"iptv/internal/app/iptv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// day 生成指定日期零点的时间
+func day(d int) time.Time {
+	return time.Date(2024, 11, d, 0, 0, 0, 0, time.Local)
+}
+
+// program 生成节目，begin和end的格式为：日期时分，如：221900
+func program(name, begin, end string) iptv.Program {
+	return iptv.Program{
+		ProgramName:     name,
+		BeginTimeFormat: "202411" + begin + "00",
+		EndTimeFormat:   "202411" + end + "00",
+		StartTime:       begin[2:4] + ":" + begin[4:],
+		EndTime:         end[2:4] + ":" + end[4:],
+	}
+}
+
+func programNames(dateProg iptv.DateProgram) []string {
+	names := make([]string, 0, len(dateProg.ProgramList))
+	for _, prog := range dateProg.ProgramList {
+		names = append(names, prog.ProgramName)
+	}
+	return names
+}
+
+func TestMergeChannelProgramLists(t *testing.T) {
+	oldLists := []iptv.ChannelProgramList{
+		{
+			ChannelId:   "1",
+			ChannelName: "CCTV-1",
+			DateProgramList: []iptv.DateProgram{
+				// 超出保留天数的日期
+				{Date: day(19), ProgramList: []iptv.Program{program("旧19-1", "190800", "190900")}},
+				// 本次未获取到的日期
+				{Date: day(20), ProgramList: []iptv.Program{program("旧20-1", "200800", "200900")}},
+				// 与新数据重叠的日期
+				{Date: day(21), ProgramList: []iptv.Program{
+					program("旧21-1", "210800", "210900"),
+					program("旧21-2", "210900", "211000"),
+					program("旧21-3", "211000", "211100"),
+					program("旧21-4", "212000", "212100"),
+				}},
+				// 新数据为空的日期
+				{Date: day(22), ProgramList: []iptv.Program{program("旧22-1", "220800", "220900")}},
+			},
+		},
+		{
+			// 仅存在于旧数据中的频道
+			ChannelId:   "2",
+			ChannelName: "CCTV-2",
+			DateProgramList: []iptv.DateProgram{
+				{Date: day(19), ProgramList: []iptv.Program{program("频道2旧19-1", "190800", "190900")}},
+				{Date: day(21), ProgramList: []iptv.Program{program("频道2旧21-1", "210800", "210900")}},
+			},
+		},
+		{
+			// 仅有超出保留天数的数据的频道
+			ChannelId:       "3",
+			ChannelName:     "CCTV-3",
+			DateProgramList: []iptv.DateProgram{{Date: day(18), ProgramList: []iptv.Program{program("频道3旧18-1", "180800", "180900")}}},
+		},
+	}
+	newLists := []iptv.ChannelProgramList{
+		{
+			ChannelId:   "1",
+			ChannelName: "CCTV-1 高清",
+			DateProgramList: []iptv.DateProgram{
+				{Date: day(19), ProgramList: []iptv.Program{program("新19-1", "190800", "190900")}},
+				{Date: day(21), ProgramList: []iptv.Program{
+					// 开始时间相同
+					program("新21-1", "210800", "210900"),
+					// 与旧节目的时间段交叉
+					program("新21-2", "210930", "211030"),
+					program("新21-3", "212100", "212200"),
+				}},
+				{Date: day(22)},
+				{Date: day(23), ProgramList: []iptv.Program{program("新23-1", "230800", "230900")}},
+			},
+		},
+		{
+			// 仅存在于新数据中的频道
+			ChannelId:       "4",
+			ChannelName:     "CCTV-4",
+			DateProgramList: []iptv.DateProgram{{Date: day(21), ProgramList: []iptv.Program{program("频道4新21-1", "210800", "210900")}}},
+		},
+	}
+
+	got := iptv.MergeChannelProgramLists(oldLists, newLists, day(20))
+
+	type dateNames struct {
+		Date  time.Time
+		Names []string
+	}
+	want := map[string][]dateNames{
+		"1": {
+			{day(20), []string{"旧20-1"}},
+			{day(21), []string{"新21-1", "新21-2", "旧21-4", "新21-3"}},
+			{day(22), []string{"旧22-1"}},
+			{day(23), []string{"新23-1"}},
+		},
+		"2": {
+			{day(21), []string{"频道2旧21-1"}},
+		},
+		"4": {
+			{day(21), []string{"频道4新21-1"}},
+		},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d channels, want %d", len(got), len(want))
+	}
+	for _, chProgList := range got {
+		wantDates, ok := want[chProgList.ChannelId]
+		if !ok {
+			t.Errorf("unexpected channel %s", chProgList.ChannelId)
+			continue
+		}
+		var gotDates []dateNames
+		for _, dateProg := range chProgList.DateProgramList {
+			gotDates = append(gotDates, dateNames{dateProg.Date, programNames(dateProg)})
+		}
+		if !reflect.DeepEqual(gotDates, wantDates) {
+			t.Errorf("channel %s got %v, want %v", chProgList.ChannelId, gotDates, wantDates)
+		}
+	}
+
+	// 以新数据中的频道名称为准
+	if got[0].ChannelId != "1" || got[0].ChannelName != "CCTV-1 高清" {
+		t.Errorf("got channel %s %s, want 1 CCTV-1 高清", got[0].ChannelId, got[0].ChannelName)
+	}
+}
+
+func TestMergeChannelProgramListsDedup(t *testing.T) {
+	oldLists := []iptv.ChannelProgramList{{
+		ChannelId: "1",
+		DateProgramList: []iptv.DateProgram{{Date: day(21), ProgramList: []iptv.Program{
+			program("旧21-1", "210800", "210900"),
+		}}},
+	}}
+	// 新数据中开始时间重复的节目只保留第一个
+	newLists := []iptv.ChannelProgramList{{
+		ChannelId: "1",
+		DateProgramList: []iptv.DateProgram{{Date: day(21), ProgramList: []iptv.Program{
+			program("新21-1", "210800", "210900"),
+			program("新21-1重复", "210800", "210900"),
+			program("新21-2", "210900", "211000"),
+		}}},
+	}}
+
+	got := iptv.MergeChannelProgramLists(oldLists, newLists, day(20))
+	if len(got) != 1 || len(got[0].DateProgramList) != 1 {
+		t.Fatalf("got %v, want 1 channel with 1 date", got)
+	}
+	names := programNames(got[0].DateProgramList[0])
+	if want := []string{"新21-1", "新21-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestNowNext(t *testing.T) {
+	chProgList := iptv.ChannelProgramList{
+		ChannelId: "1",
+		DateProgramList: []iptv.DateProgram{
+			// 列在前一天节目单中的跨零点节目
+			{Date: day(20), ProgramList: []iptv.Program{program("前一天跨零点", "202330", "210100")}},
+			{Date: day(21), ProgramList: []iptv.Program{
+				program("早间", "210600", "210800"),
+				// 08:00~09:00没有节目
+				program("上午", "210900", "211200"),
+				// 结束时间被设置为当天零点
+				program("晚间", "212300", "210000"),
+			}},
+			{Date: day(22), ProgramList: []iptv.Program{program("次日", "220030", "220100")}},
+		},
+	}
+	// 当天的最后一个节目，结束时间被设置为当天零点且没有下一个节目
+	lastProgList := iptv.ChannelProgramList{
+		ChannelId: "2",
+		DateProgramList: []iptv.DateProgram{
+			{Date: day(21), ProgramList: []iptv.Program{
+				program("倒数第二", "212000", "212300"),
+				program("最后", "212300", "210000"),
+			}},
+		},
+	}
+
+	at := func(d, hour, minute int) time.Time {
+		return time.Date(2024, 11, d, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name     string
+		list     *iptv.ChannelProgramList
+		now      time.Time
+		wantNow  string
+		wantNext string
+	}{
+		{name: "crossing midnight from previous day", list: &chProgList, now: at(21, 0, 30), wantNow: "前一天跨零点", wantNext: "早间"},
+		{name: "before the first program", list: &chProgList, now: at(21, 5, 0), wantNext: "早间"},
+		{name: "begin time", list: &chProgList, now: at(21, 6, 0), wantNow: "早间", wantNext: "上午"},
+		{name: "gap", list: &chProgList, now: at(21, 8, 30), wantNext: "上午"},
+		{name: "end time", list: &chProgList, now: at(21, 12, 0), wantNext: "晚间"},
+		{name: "end wrapped to midnight", list: &chProgList, now: at(21, 23, 30), wantNow: "晚间", wantNext: "次日"},
+		{name: "end wrapped to midnight after midnight", list: &chProgList, now: at(22, 0, 15), wantNow: "晚间", wantNext: "次日"},
+		{name: "last program", list: &chProgList, now: at(22, 0, 45), wantNow: "次日"},
+		{name: "after the last program", list: &chProgList, now: at(22, 1, 0)},
+		{name: "last program of the day", list: &lastProgList, now: at(21, 23, 59), wantNow: "最后"},
+		{name: "last program of the day ended", list: &lastProgList, now: at(22, 0, 0)},
+		{name: "no programs of nearby days", list: &chProgList, now: at(18, 12, 0)},
+		{name: "empty", list: &iptv.ChannelProgramList{}, now: at(21, 12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, next := tt.list.NowNext(tt.now)
+			if got := programName(current); got != tt.wantNow {
+				t.Errorf("got now %q, want %q", got, tt.wantNow)
+			}
+			if got := programName(next); got != tt.wantNext {
+				t.Errorf("got next %q, want %q", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func programName(program *iptv.Program) string {
+	if program == nil {
+		return ""
+	}
+	return program.ProgramName
+}
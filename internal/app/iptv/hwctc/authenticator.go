@@ -22,7 +22,12 @@ type Token struct {
 }
 
 // requestToken 请求认证的Token
-func (c *Client) requestToken(ctx context.Context) (*Token, error) {
+func (c *Client) requestToken(ctx context.Context) (token *Token, err error) {
+	start := time.Now()
+	defer func() {
+		iptv.ObserveRefresh(iptv.StageAuth, "", start, refreshResult(err))
+	}()
+
 	// 访问登录页面
 	referer, err := c.authenticationURL(ctx, true)
 	if err != nil {
@@ -61,7 +66,7 @@ func (c *Client) authenticationURL(ctx context.Context, FCCSupport bool) (string
 	c.setCommonHeaders(req)
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
@@ -72,7 +77,7 @@ func (c *Client) authenticationURL(ctx context.Context, FCCSupport bool) (string
 	}
 
 	// 服务器会302重定向，这里缓存最新的服务器地址和端口
-	c.host = resp.Request.URL.Host
+	c.setHost(resp.Request.URL.Host)
 
 	return resp.Request.URL.String(), nil
 }
@@ -91,7 +96,7 @@ func (c *Client) authLoginHWCTC(ctx context.Context, referer string) (string, er
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/authLoginHW%s.jsp", c.host, c.config.ProviderSuffix), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/authLoginHW%s.jsp", c.getHost(), c.config.ProviderSuffix), strings.NewReader(body.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -102,7 +107,7 @@ func (c *Client) authLoginHWCTC(ctx context.Context, referer string) (string, er
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
@@ -186,19 +191,19 @@ func (c *Client) validAuthenticationHWCTC(ctx context.Context, encryptToken stri
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/ValidAuthenticationHW%s.jsp", c.host, c.config.ProviderSuffix), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/ValidAuthenticationHW%s.jsp", c.getHost(), c.config.ProviderSuffix), strings.NewReader(body.Encode()))
 	if err != nil {
 		return nil, err
 	}
 
 	// 设置请求头
 	c.setCommonHeaders(req)
-	referer := fmt.Sprintf("http://%s/EPG/jsp/authLoginHW%s.jsp", c.host, c.config.ProviderSuffix)
+	referer := fmt.Sprintf("http://%s/EPG/jsp/authLoginHW%s.jsp", c.getHost(), c.config.ProviderSuffix)
 	req.Header.Set("Referer", referer)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -1,12 +1,15 @@
 package hwctc
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"iptv/internal/app/iptv"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -17,14 +20,233 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	// 频道列表页面中包含ChannelID字段的JS字符串，即单个频道配置，如：Authentication.CTCSetConfig('Channel','ChannelID="1",...')。
+	// 不限定调用方式，单引号和双引号的字符串均可
+	channelEntryRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`'((?:[^'\\]|\\.)*?ChannelID\s*=\s*(?:"|\\')(?:[^'\\]|\\.)*)'`),
+		regexp.MustCompile(`"((?:[^"\\]|\\.)*?ChannelID\s*=\s*(?:\\"|')(?:[^"\\]|\\.)*)"`),
+	}
+	// 页面中没有频道配置的JS字符串时，按ChannelID字段切分页面内容
+	channelIDFieldRegex = regexp.MustCompile(`\bChannelID\s*=\s*["']`)
+	// 频道配置中形如 Key="Value" 或 Key='Value' 的字段
+	channelFieldRegex = regexp.MustCompile(`(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	// JS字符串中的转义字符
+	jsUnescaper = strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\\`, `\`)
+
+	// 频道配置中必需的字段
+	requiredChannelFields = []string{"ChannelID", "ChannelName", "ChannelURL"}
+	// 已转换为频道信息的字段，其余字段保存在Channel.Properties中
+	knownChannelFields = map[string]struct{}{
+		"ChannelID": {}, "ChannelName": {}, "UserChannelID": {}, "ChannelURL": {},
+		"TimeShift": {}, "TimeShiftLength": {}, "TimeShiftURL": {},
+		"IsHDChannel": {}, "FCCEnable": {}, "ChannelFCCIP": {}, "ChannelFCCPort": {}, "ChannelSDP": {},
+	}
+
+	// 根据频道名称识别清晰度
+	uhdChannelNameRegex = regexp.MustCompile(`(?i)(4K|8K|超高清|UHD)`)
+	hdChannelNameRegex  = regexp.MustCompile(`(?i)(高清|HD)`)
+)
+
 // GetAllChannelList 获取所有频道列表
-func (c *Client) GetAllChannelList(ctx context.Context) ([]iptv.Channel, error) {
-	// 请求认证的Token
-	token, err := c.requestToken(ctx)
+func (c *Client) GetAllChannelList(ctx context.Context) (channels []iptv.Channel, err error) {
+	start := time.Now()
+	defer func() {
+		iptv.ObserveRefresh(iptv.StageChannelList, "", start, refreshResult(err))
+	}()
+
+	// 使用缓存的Token请求频道列表，会话失效时自动重新认证
+	var result []byte
+	err = c.doWithToken(ctx, func(token *Token) error {
+		var err error
+		result, err = c.requestChannelList(ctx, token)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// 逐条解析频道配置
+	entries := parseChannelEntries(result)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("failed to extract channel list")
+	}
+
+	channels = make([]iptv.Channel, 0, len(entries))
+	var errs []error
+	for i, fields := range entries {
+		channel, err := c.parseChannel(fields)
+		if err != nil {
+			// 记录解析失败的频道，不影响其他频道
+			c.logger.Warn("Failed to parse the channel, skip it.", zap.Int("index", i), zap.String("channelID", fields["ChannelID"]),
+				zap.String("channelName", fields["ChannelName"]), zap.Error(err))
+			errs = append(errs, fmt.Errorf("channel entry %d: %w", i, err))
+			continue
+		}
+		if channel == nil {
+			continue
+		}
+
+		channels = append(channels, *channel)
+	}
+
+	if len(channels) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return channels, nil
+}
+
+// parseChannel 将频道配置的字段转换为频道信息，被过滤的频道返回nil
+func (c *Client) parseChannel(fields map[string]string) (*iptv.Channel, error) {
+	// 校验必需的字段
+	var missing []string
+	for _, key := range requiredChannelFields {
+		if fields[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+
+	channelName := fields["ChannelName"]
+
+	// channelURL类型转换
+	// channelURL可能同时返回组播和单播多个地址（通过|分割）
+	channelURLStrList := strings.Split(fields["ChannelURL"], "|")
+	channelURLs := make([]url.URL, 0, len(channelURLStrList))
+	for _, channelURLStr := range channelURLStrList {
+		channelURL, err := url.Parse(channelURLStr)
+		if err != nil || channelURLStr == "" {
+			continue
+		}
+
+		channelURLs = append(channelURLs, *channelURL)
+	}
+
+	if len(channelURLs) == 0 {
+		return nil, fmt.Errorf("illegal channelURL: %s", fields["ChannelURL"])
+	}
+
+	// TimeShiftLength类型转换
+	var timeShiftLength int64
+	if timeShiftLengthStr := fields["TimeShiftLength"]; timeShiftLengthStr != "" {
+		var err error
+		timeShiftLength, err = strconv.ParseInt(timeShiftLengthStr, 10, 64)
+		if err != nil {
+			c.logger.Warn("The timeShiftLength of this channel is illegal. Use the default value: 0.", zap.String("channelName", channelName), zap.String("timeShiftLength", timeShiftLengthStr))
+			timeShiftLength = 0
+		}
+	}
+
+	// 解析时移地址
+	var timeShiftURL *url.URL
+	if timeShiftURLStr := fields["TimeShiftURL"]; timeShiftURLStr != "" {
+		var err error
+		timeShiftURL, err = url.Parse(timeShiftURLStr)
+		if err != nil {
+			c.logger.Warn("The timeShiftURL of this channel is illegal. Use the default value: nil.", zap.String("channelName", channelName), zap.String("timeShiftURL", timeShiftURLStr))
+		}
+	}
+	// 如果ChannelURL只返回了一个组播地址，则考虑将回看地址同时作为单播地址进行记录
+	if timeShiftURL != nil &&
+		len(channelURLs) == 1 && channelURLs[0].Scheme == iptv.SCHEME_IGMP {
+		channelURLs = append(channelURLs, *timeShiftURL)
+	}
+
+	// 保留未识别的字段
+	var properties map[string]string
+	for key, value := range fields {
+		if _, ok := knownChannelFields[key]; ok {
+			continue
+		}
+		if properties == nil {
+			properties = make(map[string]string)
+		}
+		properties[key] = value
+	}
+
+	timeShift := fields["TimeShift"]
+	if timeShift == "" {
+		timeShift = "0"
+	}
+
+	channel := iptv.Channel{
+		ChannelID:       fields["ChannelID"],
+		ChannelName:     channelName,
+		UserChannelID:   fields["UserChannelID"],
+		ChannelURLs:     channelURLs,
+		TimeShift:       timeShift,
+		TimeShiftLength: time.Duration(timeShiftLength) * time.Minute,
+		TimeShiftURL:    timeShiftURL,
+		Definition:      parseChannelDefinition(channelName, fields["IsHDChannel"]),
+		FCCEnable:       fields["FCCEnable"] == "1",
+		ChannelSDP:      fields["ChannelSDP"],
+		Properties:      properties,
+	}
+	// FCC服务器地址
+	if fccIP := fields["ChannelFCCIP"]; fccIP != "" && fields["ChannelFCCPort"] != "" {
+		channel.FCCServer = net.JoinHostPort(fccIP, fields["ChannelFCCPort"])
+	}
+
+	// 自动识别频道的分类，特殊频道不在此过滤，由调用方按当前的过滤规则处理，以便修改规则后重新过滤
+	chRules := c.chRules.Load()
+	channel.GroupName = iptv.GetChannelGroupName(chRules.GroupRulesList, &channel)
+
+	// 识别频道台标logo
+	channel.LogoName = iptv.GetChannelLogoName(chRules.LogoRuleList, channelName)
+
+	return &channel, nil
+}
+
+// parseChannelDefinition 根据频道名称和IsHDChannel字段识别频道的清晰度
+func parseChannelDefinition(channelName, isHDChannel string) string {
+	switch {
+	case uhdChannelNameRegex.MatchString(channelName) || isHDChannel == "2":
+		return iptv.DefinitionUHD
+	case isHDChannel == "1" || hdChannelNameRegex.MatchString(channelName):
+		return iptv.DefinitionHD
+	default:
+		return iptv.DefinitionSD
+	}
+}
+
+// parseChannelEntries 解析频道列表页面中的每一条频道配置（如Authentication.CTCSetConfig('Channel','...')），
+// 每条配置解析为字段名称和值的映射，不依赖字段的顺序
+func parseChannelEntries(content []byte) []map[string]string {
+	var entries []map[string]string
+	for _, entryRegex := range channelEntryRegexes {
+		for _, matches := range entryRegex.FindAllSubmatch(content, -1) {
+			entries = append(entries, parseChannelFields(jsUnescaper.Replace(string(matches[1]))))
+		}
+		if len(entries) > 0 {
+			return entries
+		}
+	}
+
+	// 兼容其他格式的页面：从每个ChannelID字段开始，至下一个ChannelID字段之前为一个频道的配置
+	locs := channelIDFieldRegex.FindAllIndex(content, -1)
+	for i, loc := range locs {
+		end := len(content)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		entries = append(entries, parseChannelFields(string(content[loc[0]:end])))
+	}
+	return entries
+}
+
+// parseChannelFields 解析单个频道配置中的字段
+func parseChannelFields(entry string) map[string]string {
+	fields := make(map[string]string)
+	for _, matches := range channelFieldRegex.FindAllStringSubmatch(entry, -1) {
+		fields[matches[1]] = matches[2] + matches[3]
+	}
+	return fields
+}
+
+// requestChannelList 请求频道列表页面
+func (c *Client) requestChannelList(ctx context.Context, token *Token) ([]byte, error) {
 	// 计算JSESSIONID的MD5
 	hash := md5.Sum([]byte(token.JSESSIONID))
 	// 转换为16进制字符串并转换为大写，即为tempKey
@@ -47,14 +269,14 @@ func (c *Client) GetAllChannelList(ctx context.Context) ([]iptv.Channel, error)
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/getchannellistHW%s.jsp", c.host, c.config.ProviderSuffix), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/getchannellistHW%s.jsp", c.getHost(), c.config.ProviderSuffix), strings.NewReader(body.Encode()))
 	if err != nil {
 		return nil, err
 	}
 
 	// 设置请求头
 	c.setCommonHeaders(req)
-	req.Header.Set("Referer", fmt.Sprintf("http://%s/EPG/jsp/ValidAuthenticationHW%s.jsp", c.host, c.config.ProviderSuffix))
+	req.Header.Set("Referer", fmt.Sprintf("http://%s/EPG/jsp/ValidAuthenticationHW%s.jsp", c.getHost(), c.config.ProviderSuffix))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// 设置Cookie
@@ -64,93 +286,25 @@ func (c *Client) GetAllChannelList(ctx context.Context) ([]iptv.Channel, error)
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
 	}
 
-	// 解析响应内容
+	// 读取响应内容
 	result, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	chRegex := regexp.MustCompile("ChannelID=\"(.+?)\",ChannelName=\"(.+?)\",UserChannelID=\"(.+?)\",ChannelURL=\"(.+?)\",TimeShift=\"(.+?)\",TimeShiftLength=\"(\\d+?)\".+?,TimeShiftURL=\"(.+?)\"")
-	matchesList := chRegex.FindAllSubmatch(result, -1)
-	if matchesList == nil {
-		return nil, fmt.Errorf("failed to extract channel list")
+	if !bytes.Contains(result, []byte("ChannelID=")) && isSessionExpiredPage(result) {
+		return nil, ErrSessionExpired
 	}
-
-	channels := make([]iptv.Channel, 0, len(matchesList))
-	for _, matches := range matchesList {
-		if len(matches) != 8 {
-			continue
-		}
-
-		channelName := string(matches[2])
-		// 过滤掉特殊频道
-		if c.chExcludeRule != nil && c.chExcludeRule.MatchString(channelName) {
-			c.logger.Warn("This is not a normal channel, skip it.", zap.String("channelName", channelName))
-			continue
-		}
-
-		// channelURL类型转换
-		// channelURL可能同时返回组播和单播多个地址（通过|分割）
-		channelURLStrList := strings.Split(string(matches[4]), "|")
-		channelURLs := make([]url.URL, 0, len(channelURLStrList))
-		for _, channelURLStr := range channelURLStrList {
-			channelURL, err := url.Parse(channelURLStr)
-			if err != nil {
-				continue
-			}
-
-			channelURLs = append(channelURLs, *channelURL)
-		}
-
-		if len(channelURLs) == 0 {
-			c.logger.Warn("The channelURL of this channel is illegal, skip it.", zap.String("channelName", channelName), zap.String("channelURL", string(matches[4])))
-			continue
-		}
-
-		// TimeShiftLength类型转换
-		timeShiftLength, err := strconv.ParseInt(string(matches[6]), 10, 64)
-		if err != nil {
-			c.logger.Warn("The timeShiftLength of this channel is illegal. Use the default value: 0.", zap.String("channelName", channelName), zap.String("timeShiftLength", string(matches[6])))
-			timeShiftLength = 0
-		}
-
-		// 解析时移地址
-		timeShiftURL, err := url.Parse(string(matches[7]))
-		if err != nil {
-			c.logger.Warn("The timeShiftURL of this channel is illegal. Use the default value: nil.", zap.String("channelName", channelName), zap.String("timeShiftURL", string(matches[7])))
-		}
-		// 如果ChannelURL只返回了一个组播地址，则考虑将回看地址同时作为单播地址进行记录
-		if timeShiftURL != nil &&
-			len(channelURLs) == 1 && channelURLs[0].Scheme == iptv.SCHEME_IGMP {
-			channelURLs = append(channelURLs, *timeShiftURL)
-		}
-
-		// 自动识别频道的分类
-		groupName := iptv.GetChannelGroupName(c.chGroupRulesList, channelName)
-
-		// 识别频道台标logo
-		logoName := iptv.GetChannelLogoName(c.chLogoRuleList, channelName)
-
-		channels = append(channels, iptv.Channel{
-			ChannelID:       string(matches[1]),
-			ChannelName:     channelName,
-			UserChannelID:   string(matches[3]),
-			ChannelURLs:     channelURLs,
-			TimeShift:       string(matches[5]),
-			TimeShiftLength: time.Duration(timeShiftLength) * time.Minute,
-			TimeShiftURL:    timeShiftURL,
-			GroupName:       groupName,
-			LogoName:        logoName,
-		})
-	}
-	return channels, nil
+	return result, nil
 }
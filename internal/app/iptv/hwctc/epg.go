@@ -5,6 +5,8 @@ import (
 	"errors"
 	"iptv/internal/app/iptv"
 	"slices"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -25,54 +27,138 @@ const (
 	chProgAPIDefaulttrans2   = "defaulttrans2"
 )
 
+// chProgAPIs 支持的EPG接口，自动选择时按此顺序依次尝试
+var chProgAPIs = []string{chProgAPILiveplay, chProgAPIGdhdpublic, chProgAPIVsp, chProgAPIStbEpg2023Group, chProgAPIDefaulttrans2}
+
+// backDaysKey ctx中只获取最近几天的节目单时的天数
+type backDaysKey struct{}
+
 type getChannelProgramListFunc func(ctx context.Context, token *Token, channel *iptv.Channel) (*iptv.ChannelProgramList, error)
 
 // GetAllChannelProgramList 获取所有频道的节目单列表
 func (c *Client) GetAllChannelProgramList(ctx context.Context, channels []iptv.Channel) ([]iptv.ChannelProgramList, error) {
-	// 请求认证的Token
-	token, err := c.requestToken(ctx)
-	if err != nil {
+	// 提前完成认证，认证失败时直接返回
+	if _, err := c.getToken(ctx); err != nil {
 		return nil, err
 	}
 
+	if api := c.getChannelProgramAPI(); slices.Contains(chProgAPIs, api) {
+		return c.getAllChannelProgramListByAPI(ctx, channels, api)
+	}
+	// 自动选择调用EPG的API接口
+	return c.getAllChannelProgramListByAuto(ctx, channels)
+}
+
+// GetRecentChannelProgramList 获取所有频道最近backDays天（为0时只获取当天）及未来的节目单列表，
+// 一次请求即返回全部日期的EPG接口（如liveplay_30）仍返回全部日期
+func (c *Client) GetRecentChannelProgramList(ctx context.Context, channels []iptv.Channel, backDays int) ([]iptv.ChannelProgramList, error) {
+	return c.GetAllChannelProgramList(context.WithValue(ctx, backDaysKey{}, max(backDays, 0)), channels)
+}
+
+// getEPGBackDay 根据当前频道的时移范围，预估EPG需要往前查询的天数（从未来一天开始计算），
+// 不超过EPG查询的最大时间范围，只获取最近几天的节目单时不超过指定的天数
+func getEPGBackDay(ctx context.Context, channel *iptv.Channel) int {
+	backDay := min(int(channel.TimeShiftLength.Hours()/24)+1, maxBackDay)
+	if backDays, ok := ctx.Value(backDaysKey{}).(int); ok {
+		backDay = min(backDay, backDays+1)
+	}
+	return backDay
+}
+
+// getAllChannelProgramListByAPI 使用指定的EPG接口获取所有频道的节目单列表，并记录耗时及结果
+func (c *Client) getAllChannelProgramListByAPI(ctx context.Context, channels []iptv.Channel, api string) ([]iptv.ChannelProgramList, error) {
+	start := time.Now()
 	var result []iptv.ChannelProgramList
-	switch c.config.ChannelProgramAPI {
+	var err error
+	switch api {
 	case chProgAPILiveplay:
-		result, err = c.getAllChannelProgramList(ctx, channels, token, c.getLiveplayChannelProgramList)
+		result, err = c.getAllChannelProgramList(ctx, channels, c.getLiveplayChannelProgramList)
 	case chProgAPIGdhdpublic:
-		result, err = c.getAllChannelProgramList(ctx, channels, token, c.getGdhdpublicChannelProgramList)
+		result, err = c.getAllChannelProgramList(ctx, channels, c.getGdhdpublicChannelProgramList)
 	case chProgAPIVsp:
-		result, err = c.getAllChannelProgramList(ctx, channels, token, c.getVspChannelProgramList)
+		result, err = c.getAllChannelProgramList(ctx, channels, c.getVspChannelProgramList)
 	case chProgAPIStbEpg2023Group:
-		result, err = c.getStbEpg2023GroupAllChannelProgramList(ctx, channels, token)
+		result, err = c.getStbEpg2023GroupAllChannelProgramList(ctx, channels)
 	case chProgAPIDefaulttrans2:
-		result, err = c.getAllChannelProgramList(ctx, channels, token, c.getDefaulttrans2ChannelProgramList)
+		result, err = c.getAllChannelProgramList(ctx, channels, c.getDefaulttrans2ChannelProgramList)
 	default:
-		// 自动选择调用EPG的API接口
-		result, err = c.getAllChannelProgramListByAuto(ctx, channels, token)
+		return nil, ErrEPGApiNotFound
 	}
 
+	iptv.ObserveRefresh(iptv.StageEPG, api, start, refreshResult(err))
 	return result, err
 }
 
 // getAllChannelProgramList 获取所有频道的节目单列表
-func (c *Client) getAllChannelProgramList(ctx context.Context, channels []iptv.Channel, token *Token, getChProgFunc getChannelProgramListFunc) ([]iptv.ChannelProgramList, error) {
-	epg := make([]iptv.ChannelProgramList, 0, len(channels))
-	for _, channel := range channels {
+func (c *Client) getAllChannelProgramList(ctx context.Context, channels []iptv.Channel, getChProgFunc getChannelProgramListFunc) ([]iptv.ChannelProgramList, error) {
+	return c.fetchAllChannelProgramList(ctx, channels, func(ctx context.Context, channel *iptv.Channel) (*iptv.ChannelProgramList, error) {
+		// 使用缓存的Token获取节目单，会话失效时自动重新认证
+		var progList *iptv.ChannelProgramList
+		err := c.doWithToken(ctx, func(token *Token) error {
+			var err error
+			progList, err = getChProgFunc(ctx, token, channel)
+			return err
+		})
+		return progList, err
+	})
+}
+
+// fetchAllChannelProgramList 通过有限数量的协程并发获取所有频道的节目单列表，结果按频道的顺序返回
+func (c *Client) fetchAllChannelProgramList(ctx context.Context, channels []iptv.Channel,
+	fetch func(ctx context.Context, channel *iptv.Channel) (*iptv.ChannelProgramList, error)) ([]iptv.ChannelProgramList, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]*iptv.ChannelProgramList, len(channels))
+	indexCh := make(chan int)
+
+	var wg sync.WaitGroup
+	for range c.config.EPGWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexCh {
+				channel := &channels[i]
+				progList, err := fetch(ctx, channel)
+				if err != nil {
+					if errors.Is(err, ErrEPGApiNotFound) {
+						// EPG接口不存在，停止获取其他频道的节目单
+						cancel(err)
+					} else if ctx.Err() == nil {
+						iptv.ObserveEPGChannelFailure(channel)
+						c.logger.Sugar().Warnf("Failed to get the program list for channel %s. Error: %v", channel.ChannelName, err)
+					}
+					continue
+				}
+				results[i] = progList
+			}
+		}()
+	}
+
+	// 分发需要获取节目单的频道
+dispatch:
+	for i, channel := range channels {
 		// 跳过不支持回看的频道
 		if channel.TimeShift != "1" || channel.TimeShiftLength <= 0 {
 			continue
 		}
 
-		progList, err := getChProgFunc(ctx, token, &channel)
-		if err != nil {
-			if errors.Is(err, ErrEPGApiNotFound) {
-				return nil, err
-			}
-			c.logger.Sugar().Warnf("Failed to get the program list for channel %s. Error: %v", channel.ChannelName, err)
-			continue
+		select {
+		case indexCh <- i:
+		case <-ctx.Done():
+			break dispatch
 		}
+	}
+	close(indexCh)
+	wg.Wait()
 
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	// 按频道的顺序组装结果
+	epg := make([]iptv.ChannelProgramList, 0, len(channels))
+	for _, progList := range results {
 		if progList != nil && len(progList.DateProgramList) > 0 {
 			// 对频道的节目单按日期升序排序
 			slices.SortFunc(progList.DateProgramList, func(a, b iptv.DateProgram) int {
@@ -86,42 +172,30 @@ func (c *Client) getAllChannelProgramList(ctx context.Context, channels []iptv.C
 }
 
 // getAllChannelProgramListByAuto 自动选择调用EPG的API接口
-func (c *Client) getAllChannelProgramListByAuto(ctx context.Context, channels []iptv.Channel, token *Token) ([]iptv.ChannelProgramList, error) {
-	result, err := c.getAllChannelProgramList(ctx, channels, token, c.getLiveplayChannelProgramList)
-	if !errors.Is(err, ErrEPGApiNotFound) {
-		c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", chProgAPILiveplay))
-		c.config.ChannelProgramAPI = chProgAPILiveplay
-		return result, err
-	}
-
-	result, err = c.getAllChannelProgramList(ctx, channels, token, c.getGdhdpublicChannelProgramList)
-	if !errors.Is(err, ErrEPGApiNotFound) {
-		c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", chProgAPIGdhdpublic))
-		c.config.ChannelProgramAPI = chProgAPIGdhdpublic
-		return result, err
-	}
-
-	result, err = c.getAllChannelProgramList(ctx, channels, token, c.getVspChannelProgramList)
-	if !errors.Is(err, ErrEPGApiNotFound) {
-		c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", chProgAPIVsp))
-		c.config.ChannelProgramAPI = chProgAPIVsp
-		return result, err
-	}
-
-	result, err = c.getStbEpg2023GroupAllChannelProgramList(ctx, channels, token)
-	if !errors.Is(err, ErrEPGApiNotFound) {
-		c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", chProgAPIStbEpg2023Group))
-		c.config.ChannelProgramAPI = chProgAPIStbEpg2023Group
-		return result, err
-	}
-
-	result, err = c.getAllChannelProgramList(ctx, channels, token, c.getDefaulttrans2ChannelProgramList)
-	if !errors.Is(err, ErrEPGApiNotFound) {
-		c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", chProgAPIDefaulttrans2))
-		c.config.ChannelProgramAPI = chProgAPIDefaulttrans2
-		return result, err
+func (c *Client) getAllChannelProgramListByAuto(ctx context.Context, channels []iptv.Channel) ([]iptv.ChannelProgramList, error) {
+	var err error
+	for _, api := range chProgAPIs {
+		var result []iptv.ChannelProgramList
+		result, err = c.getAllChannelProgramListByAPI(ctx, channels, api)
+		if !errors.Is(err, ErrEPGApiNotFound) {
+			c.logger.Info("An available EPG API was found.", zap.String("channelProgramAPI", api))
+			c.setChannelProgramAPI(api)
+			return result, err
+		}
 	}
 
 	c.logger.Warn("No suitable EPG API found.")
 	return nil, err
 }
+
+// refreshResult 根据错误获取刷新阶段的结果
+func refreshResult(err error) string {
+	switch {
+	case err == nil:
+		return iptv.ResultSuccess
+	case errors.Is(err, ErrEPGApiNotFound):
+		return iptv.ResultNotFound
+	default:
+		return iptv.ResultError
+	}
+}
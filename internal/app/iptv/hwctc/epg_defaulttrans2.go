@@ -8,11 +8,15 @@ import (
 	"iptv/internal/app/iptv"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// 节目的集数，如：第6集
+var episodeNumRegex = regexp.MustCompile(`^第\s*\d+\s*集$`)
+
 type defaulttrans2Respone struct {
 	Data  []defaulttrans2ChannelProg `json:"data"`
 	Title []string                   `json:"title"`
@@ -42,7 +46,7 @@ func (c *Client) getDefaulttrans2ChannelProgramList(ctx context.Context, token *
 		// 获取指定日期的节目单列表
 		programList, chDateSize, err := c.getDefaulttrans2ChannelDateProgram(ctx, token, channel, date, -i)
 		if err != nil {
-			if errors.Is(err, ErrEPGApiNotFound) {
+			if errors.Is(err, ErrEPGApiNotFound) || errors.Is(err, ErrSessionExpired) {
 				return nil, err
 			}
 			c.logger.Sugar().Warnf("Failed to get the program list for channel %s on %s (index: %d). Error: %v", channel.ChannelName, date.Format("20060102"), -i, err)
@@ -51,6 +55,10 @@ func (c *Client) getDefaulttrans2ChannelProgramList(ctx context.Context, token *
 
 		if i == 0 {
 			dateSize = chDateSize
+			// 只获取最近几天的节目单
+			if backDays, ok := ctx.Value(backDaysKey{}).(int); ok {
+				dateSize = min(dateSize, backDays+1)
+			}
 		}
 		dateProgramList = append(dateProgramList, iptv.DateProgram{
 			Date:        date,
@@ -69,7 +77,7 @@ func (c *Client) getDefaulttrans2ChannelProgramList(ctx context.Context, token *
 func (c *Client) getDefaulttrans2ChannelDateProgram(ctx context.Context, token *Token, channel *iptv.Channel, date time.Time, index int) ([]iptv.Program, int, error) {
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
-		fmt.Sprintf("http://%s/EPG/jsp/defaulttrans2/en/datajsp/getTvodProgListByIndex.jsp", c.host), nil)
+		fmt.Sprintf("http://%s/EPG/jsp/defaulttrans2/en/datajsp/getTvodProgListByIndex.jsp", c.getHost()), nil)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -82,7 +90,7 @@ func (c *Client) getDefaulttrans2ChannelDateProgram(ctx context.Context, token *
 
 	// 设置请求头
 	c.setCommonHeaders(req)
-	req.Header.Set("Referer", fmt.Sprintf("http://%s/EPG/jsp/defaulttrans2/en/chanMiniList.html", c.host))
+	req.Header.Set("Referer", fmt.Sprintf("http://%s/EPG/jsp/defaulttrans2/en/chanMiniList.html", c.getHost()))
 
 	// 设置Cookie
 	cookies := []*http.Cookie{
@@ -100,13 +108,15 @@ func (c *Client) getDefaulttrans2ChannelDateProgram(ctx context.Context, token *
 	}
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, 0, err
+	} else if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
 		return nil, 0, ErrEPGApiNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return nil, 0, fmt.Errorf("http status code: %d", resp.StatusCode)
@@ -171,13 +181,20 @@ func parseDefaulttrans2ChannelDateProgram(response defaulttrans2Respone, date ti
 		}
 
 		// 组装节目单对象
-		programList = append(programList, iptv.Program{
+		program := iptv.Program{
 			ProgramName:     prog.ProgName,
 			BeginTimeFormat: bTime.Format("20060102150405"),
 			EndTimeFormat:   eTime.Format("20060102150405"),
 			StartTime:       startTimeStr,
 			EndTime:         endTimeStr,
-		})
+		}
+		// 子标题一般为集数，如：第6集，否则作为节目描述
+		if episodeNumRegex.MatchString(prog.SubProgName) {
+			program.EpisodeNum = prog.SubProgName
+		} else {
+			program.Desc = prog.SubProgName
+		}
+		programList = append(programList, program)
 		// 丢弃后续第二天的节目单数据，如果存在的话
 		if endTimeStr == "23:59" {
 			break
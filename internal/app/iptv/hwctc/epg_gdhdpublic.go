@@ -32,11 +32,7 @@ func (c *Client) getGdhdpublicChannelProgramList(ctx context.Context, token *Tok
 	tomorrow = time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, tomorrow.Location())
 
 	// 根据当前频道的时移范围，预估EPG的查询时间范围（加上未来一天）
-	epgBackDay := int(channel.TimeShiftLength.Hours()/24) + 1
-	// 限制EPG查询的最大时间范围
-	if epgBackDay > maxBackDay {
-		epgBackDay = maxBackDay
-	}
+	epgBackDay := getEPGBackDay(ctx, channel)
 
 	// 从未来一天开始往前，倒查多个日期的节目单
 	dateProgramList := make([]iptv.DateProgram, 0, epgBackDay+1)
@@ -47,7 +43,7 @@ func (c *Client) getGdhdpublicChannelProgramList(ctx context.Context, token *Tok
 		// 获取指定日期的节目单列表
 		programList, err := c.getGdhdpublicChannelDateProgram(ctx, token, channel.ChannelID, dateStr)
 		if err != nil {
-			if errors.Is(err, ErrEPGApiNotFound) {
+			if errors.Is(err, ErrEPGApiNotFound) || errors.Is(err, ErrSessionExpired) {
 				return nil, err
 			}
 			c.logger.Sugar().Warnf("Failed to get the program list for channel %s on %s. Error: %v", channel.ChannelName, dateStr, err)
@@ -71,7 +67,7 @@ func (c *Client) getGdhdpublicChannelProgramList(ctx context.Context, token *Tok
 func (c *Client) getGdhdpublicChannelDateProgram(ctx context.Context, token *Token, channelId string, dateStr string) ([]iptv.Program, error) {
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
-		fmt.Sprintf("http://%s/EPG/jsp/gdhdpublic/Ver.3/common/data.jsp", c.host), nil)
+		fmt.Sprintf("http://%s/EPG/jsp/gdhdpublic/Ver.3/common/data.jsp", c.getHost()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -97,13 +93,15 @@ func (c *Client) getGdhdpublicChannelDateProgram(ctx context.Context, token *Tok
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
 		return nil, ErrEPGApiNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
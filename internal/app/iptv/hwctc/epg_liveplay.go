@@ -15,7 +15,7 @@ import (
 func (c *Client) getLiveplayChannelProgramList(ctx context.Context, token *Token, channel *iptv.Channel) (*iptv.ChannelProgramList, error) {
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
-		fmt.Sprintf("http://%s/EPG/jsp/liveplay_30/en/getTvodData.jsp", c.host), nil)
+		fmt.Sprintf("http://%s/EPG/jsp/liveplay_30/en/getTvodData.jsp", c.getHost()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -35,13 +35,15 @@ func (c *Client) getLiveplayChannelProgramList(ctx context.Context, token *Token
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
 		return nil, ErrEPGApiNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
@@ -57,6 +59,9 @@ func (c *Client) getLiveplayChannelProgramList(ctx context.Context, token *Token
 	regex := regexp.MustCompile("parent.jsonBackLookStr = (.+?);")
 	matches := regex.FindSubmatch(result)
 	if len(matches) != 2 {
+		if isSessionExpiredPage(result) {
+			return nil, ErrSessionExpired
+		}
 		return nil, ErrParseChProgList
 	}
 
@@ -8,7 +8,6 @@ import (
 	"iptv/internal/pkg/util"
 	"net/http"
 	"net/url"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -46,15 +45,19 @@ type stbEpg2023GroupChannelProg struct {
 }
 
 // getStbEpg2023GroupAllChannelProgramList 获取全部频道的节目单列表（fj）
-func (c *Client) getStbEpg2023GroupAllChannelProgramList(ctx context.Context, channels []iptv.Channel, token *Token) ([]iptv.ChannelProgramList, error) {
-	// 获取“全部”类别的ID
-	categoryID, err := c.getStbEpg2023GroupChannelCategoryID(ctx, "全部", token)
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) getStbEpg2023GroupAllChannelProgramList(ctx context.Context, channels []iptv.Channel) ([]iptv.ChannelProgramList, error) {
+	var stbEpg2023GrouChList []stbEpg2023GroupChannel
+	err := c.doWithToken(ctx, func(token *Token) error {
+		// 获取“全部”类别的ID
+		categoryID, err := c.getStbEpg2023GroupChannelCategoryID(ctx, "全部", token)
+		if err != nil {
+			return err
+		}
 
-	// 获取所有频道列表的code
-	stbEpg2023GrouChList, err := c.getStbEpg2023GroupChannelList(ctx, categoryID, token)
+		// 获取所有频道列表的code
+		stbEpg2023GrouChList, err = c.getStbEpg2023GroupChannelList(ctx, categoryID, token)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -64,36 +67,22 @@ func (c *Client) getStbEpg2023GroupAllChannelProgramList(ctx context.Context, ch
 		chIdCodeMap[stbEpg2023GrouCh.ID] = stbEpg2023GrouCh.Code
 	}
 
-	epg := make([]iptv.ChannelProgramList, 0, len(channels))
-	for _, channel := range channels {
-		// 跳过不支持回看的频道
-		if channel.TimeShift != "1" || channel.TimeShiftLength <= 0 {
-			continue
-		}
-
+	return c.fetchAllChannelProgramList(ctx, channels, func(ctx context.Context, channel *iptv.Channel) (*iptv.ChannelProgramList, error) {
 		chCode, ok := chIdCodeMap[channel.ChannelID]
 		if !ok {
 			c.logger.Sugar().Warnf("Failed to get the code for channel %s.", channel.ChannelName)
-			continue
+			return nil, nil
 		}
 
 		// 获取单个频道的全部节目单列表
-		progList, err := c.getStbEpg2023GroupChannelProgramList(ctx, token, &channel, chCode)
-		if err != nil {
-			c.logger.Sugar().Warnf("Failed to get the program list for channel %s. Error: %v", channel.ChannelName, err)
-			continue
-		}
-
-		if progList != nil && len(progList.DateProgramList) > 0 {
-			// 对频道的节目单按日期升序排序
-			slices.SortFunc(progList.DateProgramList, func(a, b iptv.DateProgram) int {
-				return a.Date.Compare(b.Date)
-			})
-
-			epg = append(epg, *progList)
-		}
-	}
-	return epg, nil
+		var progList *iptv.ChannelProgramList
+		err := c.doWithToken(ctx, func(token *Token) error {
+			var err error
+			progList, err = c.getStbEpg2023GroupChannelProgramList(ctx, token, channel, chCode)
+			return err
+		})
+		return progList, err
+	})
 }
 
 // getChannelCate 获取指定频道类别的ID
@@ -109,7 +98,7 @@ func (c *Client) getStbEpg2023GroupChannelCategoryID(ctx context.Context, catego
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getProperties.jsp", c.host), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getProperties.jsp", c.getHost()), strings.NewReader(body.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -126,13 +115,15 @@ func (c *Client) getStbEpg2023GroupChannelCategoryID(ctx context.Context, catego
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return "", err
+	} else if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
 		return "", ErrEPGApiNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("http status code: %d", resp.StatusCode)
@@ -178,7 +169,7 @@ func (c *Client) getStbEpg2023GroupChannelList(ctx context.Context, categoryID s
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getChannelByAjax.jsp", c.host), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getChannelByAjax.jsp", c.getHost()), strings.NewReader(body.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -195,13 +186,15 @@ func (c *Client) getStbEpg2023GroupChannelList(ctx context.Context, categoryID s
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
 	}
 
@@ -223,11 +216,7 @@ func (c *Client) getStbEpg2023GroupChannelList(ctx context.Context, categoryID s
 // getStbEpg2023GroupChannelProgramList 获取指定频道的节目单列表
 func (c *Client) getStbEpg2023GroupChannelProgramList(ctx context.Context, token *Token, channel *iptv.Channel, chCode string) (*iptv.ChannelProgramList, error) {
 	// 根据当前频道的时移范围，预估EPG的查询时间范围（加上未来一天）
-	epgBackDay := int(channel.TimeShiftLength.Hours()/24) + 1
-	// 限制EPG查询的最大时间范围
-	if epgBackDay > maxBackDay {
-		epgBackDay = maxBackDay
-	}
+	epgBackDay := getEPGBackDay(ctx, channel)
 
 	// 计算开始、结束时间
 	tomorrow := time.Now().AddDate(0, 0, 1)
@@ -252,7 +241,7 @@ func (c *Client) getStbEpg2023GroupChannelProgramList(ctx context.Context, token
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getChannelByAjax.jsp", c.host), strings.NewReader(body.Encode()))
+		fmt.Sprintf("http://%s/EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getChannelByAjax.jsp", c.getHost()), strings.NewReader(body.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -269,13 +258,15 @@ func (c *Client) getStbEpg2023GroupChannelProgramList(ctx context.Context, token
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
 	}
 
@@ -78,11 +78,7 @@ func (c *Client) getVspChannelProgramList(ctx context.Context, token *Token, cha
 	tomorrow = time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, tomorrow.Location())
 
 	// 根据当前频道的时移范围，预估EPG的查询时间范围（加上未来一天）
-	epgBackDay := int(channel.TimeShiftLength.Hours()/24) + 1
-	// 限制EPG查询的最大时间范围
-	if epgBackDay > maxBackDay {
-		epgBackDay = maxBackDay
-	}
+	epgBackDay := getEPGBackDay(ctx, channel)
 
 	// 从未来一天开始往前，倒查多个日期的节目单
 	dateProgramList := make([]iptv.DateProgram, 0, epgBackDay+1)
@@ -94,7 +90,7 @@ func (c *Client) getVspChannelProgramList(ctx context.Context, token *Token, cha
 		// 获取指定日期的节目单列表
 		programList, err := c.getVspChannelDateProgram(ctx, token, channel.ChannelID, startDate.UnixMilli(), endDate.UnixMilli(), 0)
 		if err != nil {
-			if errors.Is(err, ErrEPGApiNotFound) {
+			if errors.Is(err, ErrEPGApiNotFound) || errors.Is(err, ErrSessionExpired) {
 				return nil, err
 			}
 			c.logger.Sugar().Warnf("Failed to get the program list for channel %s on %s. Error: %v", channel.ChannelName, startDate.Format("20060102"), err)
@@ -145,7 +141,7 @@ func (c *Client) getVspChannelDateProgram(ctx context.Context, token *Token, cha
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		fmt.Sprintf("http://%s/VSP/V3/QueryPlaybillList", c.host), bytes.NewReader(payloadBytes))
+		fmt.Sprintf("http://%s/VSP/V3/QueryPlaybillList", c.getHost()), bytes.NewReader(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -162,13 +158,15 @@ func (c *Client) getVspChannelDateProgram(ctx context.Context, token *Token, cha
 	})
 
 	// 执行请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doEPG(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+	if err = checkSessionExpired(req, resp); err != nil {
+		return nil, err
+	} else if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
 		return nil, ErrEPGApiNotFound
 	} else if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http status code: %d", resp.StatusCode)
@@ -234,13 +232,18 @@ func parseVspChannelDateProgram(playbillLites []vspResponsePlaybillLite) ([]iptv
 			endTimeStr = "23:59"
 		}
 
-		programList = append(programList, iptv.Program{
+		program := iptv.Program{
 			ProgramName:     playbillLite.Name,
 			BeginTimeFormat: bTime.Format("20060102150405"),
 			EndTimeFormat:   eTime.Format("20060102150405"),
 			StartTime:       bTime.Format("15:04"),
 			EndTime:         endTimeStr,
-		})
+		}
+		// 节目分级
+		if playbillLite.Rating != nil {
+			program.Rating = playbillLite.Rating.Name
+		}
+		programList = append(programList, program)
 	}
 	return programList, nil
 }
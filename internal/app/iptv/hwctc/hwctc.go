@@ -1,33 +1,74 @@
 package hwctc
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"iptv/internal/app/iptv"
+	"iptv/internal/pkg/util"
 	"net/http"
-	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 type Client struct {
-	httpClient       *http.Client             // HTTP客户端
-	config           *Config                  // hwctc相关配置
-	key              string                   // 加密Authenticator的秘钥
-	originHost       string                   // HTTP请求的服务器地址端口
-	headers          map[string]string        // 自定义HTTP请求头
-	chExcludeRule    *regexp.Regexp           // 频道的过滤规则
-	chGroupRulesList []iptv.ChannelGroupRules // 频道分组的规则
-	chLogoRuleList   []iptv.ChannelLogoRule   // 频道台标的匹配规则
+	httpClient *http.Client                      // HTTP客户端
+	config     *Config                           // hwctc相关配置
+	key        string                            // 加密Authenticator的秘钥
+	originHost string                            // HTTP请求的服务器地址端口
+	headers    map[string]string                 // 自定义HTTP请求头
+	chRules    atomic.Pointer[iptv.ChannelRules] // 频道的分组及台标匹配规则，支持在运行时替换
 
-	host string // 缓存最新重定向的服务器地址和端口
+	hostMu sync.RWMutex // 保护host
+	host   string       // 缓存最新重定向的服务器地址和端口
+
+	chProgAPIMu sync.RWMutex // 保护config.ChannelProgramAPI，自动选择EPG接口时会进行修改
+
+	limiter *util.RateLimiter // 获取节目单的HTTP请求的速率限制
+
+	tokenMu       sync.Mutex    // 保护Token缓存
+	token         *Token        // 缓存的认证Token
+	tokenTime     time.Time     // Token的获取时间
+	tokenLifetime time.Duration // 观测到的Token有效期，未观测到时为0
 
 	logger *zap.Logger // 日志
 }
 
-var _ iptv.Client = (*Client)(nil)
+// PlatformName hw平台的名称
+const PlatformName = "hwctc"
+
+var (
+	_ iptv.Client                    = (*Client)(nil)
+	_ iptv.SessionProvider           = (*Client)(nil)
+	_ iptv.StatusProvider            = (*Client)(nil)
+	_ iptv.ChannelRulesUpdater       = (*Client)(nil)
+	_ iptv.RecentProgramListProvider = (*Client)(nil)
+)
+
+func init() {
+	// 注册hw平台
+	iptv.RegisterProvider(&iptv.Provider{
+		Name:            PlatformName,
+		SensitiveFields: []string{"Authenticator", "UserToken", "UserID", "JSESSIONID", "STBID", "MAC"},
+		DefaultConfig: func() any {
+			return &Config{}
+		},
+		NewClient: func(decode iptv.ConfigDecoder, opts *iptv.ClientOptions) (iptv.Client, error) {
+			var config Config
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			return NewClient(opts.HTTPClient, &config, opts.Key, opts.ServerHost, opts.Headers,
+				opts.ChGroupRulesList, opts.ChLogoRuleList)
+		},
+	})
+}
 
 func NewClient(httpClient *http.Client, config *Config, key, serverHost string, headers map[string]string,
-	chExcludeRule *regexp.Regexp, chGroupRulesList []iptv.ChannelGroupRules, chLogoRuleList []iptv.ChannelLogoRule) (iptv.Client, error) {
+	chGroupRulesList []iptv.ChannelGroupRules, chLogoRuleList []iptv.ChannelLogoRule) (iptv.Client, error) {
 	// config不能为空
 	if config == nil {
 		return nil, fmt.Errorf("client config is nil")
@@ -43,25 +84,91 @@ func NewClient(httpClient *http.Client, config *Config, key, serverHost string,
 	}
 
 	i := Client{
-		httpClient:       httpClient,
-		config:           config,
-		key:              key,
-		originHost:       serverHost,
-		headers:          headers,
-		chExcludeRule:    chExcludeRule,
-		chGroupRulesList: chGroupRulesList,
-		chLogoRuleList:   chLogoRuleList,
-		host:             serverHost,
-		logger:           zap.L(),
+		httpClient: httpClient,
+		config:     config,
+		key:        key,
+		originHost: serverHost,
+		headers:    headers,
+		host:       serverHost,
+		limiter:    util.NewRateLimiter(config.EPGRateLimit),
+		logger:     zap.L(),
 	}
 	if i.httpClient == nil {
 		i.httpClient = http.DefaultClient
 	}
+	i.chRules.Store(&iptv.ChannelRules{
+		GroupRulesList: chGroupRulesList,
+		LogoRuleList:   chLogoRuleList,
+	})
 	return &i, nil
 }
 
+// UpdateChannelRules 替换频道的分组及台标匹配规则，下次获取频道列表时生效
+func (c *Client) UpdateChannelRules(rules *iptv.ChannelRules) {
+	c.chRules.Store(rules)
+}
+
+// getHost 获取最新重定向的服务器地址和端口
+func (c *Client) getHost() string {
+	c.hostMu.RLock()
+	defer c.hostMu.RUnlock()
+	return c.host
+}
+
+// setHost 缓存最新重定向的服务器地址和端口
+func (c *Client) setHost(host string) {
+	c.hostMu.Lock()
+	defer c.hostMu.Unlock()
+	c.host = host
+}
+
+// do 执行HTTP请求，并对请求进行超时控制
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	// 为单个请求设置超时时间，直至响应体被关闭
+	ctx, cancel := context.WithTimeout(req.Context(), c.config.RequestTimeout)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		iptv.ObserveUpstreamRequest(req.URL.Path, 0)
+		return nil, err
+	}
+	iptv.ObserveUpstreamRequest(req.URL.Path, resp.StatusCode)
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// doEPG 执行获取节目单的HTTP请求，请求数量较多，需进行限速
+func (c *Client) doEPG(req *http.Request) (*http.Response, error) {
+	if err := c.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+// getChannelProgramAPI 获取当前使用的EPG接口
+func (c *Client) getChannelProgramAPI() string {
+	c.chProgAPIMu.RLock()
+	defer c.chProgAPIMu.RUnlock()
+	return c.config.ChannelProgramAPI
+}
+
+// setChannelProgramAPI 缓存自动选择的EPG接口
+func (c *Client) setChannelProgramAPI(api string) {
+	c.chProgAPIMu.Lock()
+	defer c.chProgAPIMu.Unlock()
+	c.config.ChannelProgramAPI = api
+}
+
+// Status 获取客户端当前使用的服务器地址及EPG接口
+func (c *Client) Status() iptv.ClientStatus {
+	return iptv.ClientStatus{
+		Host:              c.getHost(),
+		ChannelProgramAPI: c.getChannelProgramAPI(),
+	}
+}
+
 func (c *Client) setCommonHeaders(req *http.Request) {
-	req.Header.Set("Host", c.host)
+	req.Header.Set("Host", c.getHost())
 	// 设置自定义HTTP请求头
 	if len(c.headers) > 0 {
 		for k, v := range c.headers {
@@ -69,3 +176,15 @@ func (c *Client) setCommonHeaders(req *http.Request) {
 		}
 	}
 }
+
+// cancelReadCloser 关闭响应体时同时释放请求的context
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
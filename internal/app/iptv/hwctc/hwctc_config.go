@@ -2,11 +2,16 @@ package hwctc
 
 import (
 	"errors"
+	"time"
 )
 
 const (
 	providerSuffixCTC = "CTC"
 	providerSuffixCU  = "CU"
+
+	defaultEPGWorkers     = 4
+	defaultEPGRateLimit   = 8
+	defaultRequestTimeout = 10 * time.Second
 )
 
 type Config struct {
@@ -15,6 +20,10 @@ type Config struct {
 	// 以下信息均可通过抓包获取
 	IP                string `json:"ip" yaml:"ip"`                                                   // 生成Authenticator所需的IP地址。可随便一个地址，或者通过配置`interfaceName`动态获取
 	ChannelProgramAPI string `json:"channelProgramAPI,omitempty" yaml:"channelProgramAPI,omitempty"` // 请求频道节目信息（EPG）的API接口，目前只支持两种：liveplay_30或者gdhdpublic。
+	// 以下为请求节目单（EPG）时的并发控制
+	EPGWorkers     int           `json:"epgWorkers,omitempty" yaml:"epgWorkers,omitempty"`         // 并发获取节目单的频道数
+	EPGRateLimit   float64       `json:"epgRateLimit,omitempty" yaml:"epgRateLimit,omitempty"`     // 获取节目单时每秒最多发起的HTTP请求数，小于0时不限制
+	RequestTimeout time.Duration `json:"requestTimeout,omitempty" yaml:"requestTimeout,omitempty"` // 单个HTTP请求的超时时间
 	// 以下信息均可通过抓包请求ValidAuthenticationHWCTC.jsp的参数拿到
 	UserID           string `json:"userID" yaml:"userID"`
 	Lang             string `json:"lang,omitempty" yaml:"lang,omitempty"`           // 如果没有可以不填
@@ -50,5 +59,16 @@ func (c *Config) Validate() error {
 		c.ProviderSuffix = providerSuffixCTC
 	}
 
+	// 设置默认的并发控制参数
+	if c.EPGWorkers <= 0 {
+		c.EPGWorkers = defaultEPGWorkers
+	}
+	if c.EPGRateLimit == 0 {
+		c.EPGRateLimit = defaultEPGRateLimit
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = defaultRequestTimeout
+	}
+
 	return nil
 }
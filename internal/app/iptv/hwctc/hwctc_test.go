@@ -0,0 +1,452 @@
+package hwctc_test
+
+import (
+	"context"
+	"iptv/internal/app/iptv"
+	"iptv/internal/app/iptv/hwctc"
+	"iptv/internal/app/iptv/hwctc/hwctctest"
+	"iptv/internal/pkg/capture"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testKey    = "12345678"
+	testUserID = "test_user"
+	testSTBID  = "00100599007060400000BC6202A5A7A7"
+)
+
+var (
+	testChExcludeRule, _ = iptv.ParseChannelRule("^.*?(画中画|单音轨|-体验|\\(测试\\)|直播室\\d+)")
+	testChHDRule, _      = iptv.ParseChannelRule("definition:^HD$")
+	testChGroupRulesList = []iptv.ChannelGroupRules{{Name: "高清", Rules: []*iptv.ChannelRule{testChHDRule}}}
+)
+
+func newTestServer(t *testing.T, epgAPI string) *hwctctest.Server {
+	t.Helper()
+	server := hwctctest.NewServer(hwctctest.Options{
+		Key:    testKey,
+		UserID: testUserID,
+		EPGAPI: epgAPI,
+	})
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, server *hwctctest.Server, key, channelProgramAPI string) iptv.Client {
+	t.Helper()
+	return newTestClientWithHTTPClient(t, &http.Client{}, server.Host(), key, channelProgramAPI)
+}
+
+func newTestClientWithHTTPClient(t *testing.T, httpClient *http.Client, serverHost, key, channelProgramAPI string) iptv.Client {
+	t.Helper()
+	return newTestClientWithConfig(t, httpClient, serverHost, key, newTestConfig(channelProgramAPI))
+}
+
+func newTestConfig(channelProgramAPI string) *hwctc.Config {
+	return &hwctc.Config{
+		IP:                "10.0.0.2",
+		ChannelProgramAPI: channelProgramAPI,
+		EPGRateLimit:      -1,
+		UserID:            testUserID,
+		STBType:           "EC6108V9U_pub_jljlt",
+		STBVersion:        "V100R003C20LJLD18B010",
+		Conntype:          "dhcp",
+		STBID:             testSTBID,
+		MAC:               "BC:62:02:A5:A7:A7",
+		SoftwareVersion:   "V100R003C20LJLD18B010",
+	}
+}
+
+func newTestClientWithConfig(t *testing.T, httpClient *http.Client, serverHost, key string, config *hwctc.Config) iptv.Client {
+	t.Helper()
+	client, err := hwctc.NewClient(httpClient, config, key, serverHost, nil, testChGroupRulesList, nil)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestGetAllChannelList(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, server, testKey, "")
+
+	channels, err := client.GetAllChannelList(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+
+	// 缺少ChannelURL的频道解析失败，画中画频道由调用方按过滤规则过滤
+	if len(channels) != 6 {
+		t.Fatalf("got %d channels, want 6", len(channels))
+	}
+	channels = (&iptv.ChannelRules{ExcludeRule: testChExcludeRule, GroupRulesList: testChGroupRulesList}).Apply(channels)
+	if len(channels) != 5 {
+		t.Fatalf("got %d channels after exclusion, want 5", len(channels))
+	}
+	ch := channels[0]
+	if ch.ChannelID != "1001" || ch.ChannelName != "CCTV-1高清" || ch.UserChannelID != "1" {
+		t.Errorf("unexpected channel: %+v", ch)
+	}
+	if len(ch.ChannelURLs) != 2 || ch.ChannelURLs[0].Scheme != iptv.SCHEME_IGMP || ch.ChannelURLs[1].Scheme != "rtsp" {
+		t.Errorf("unexpected channel URLs: %v", ch.ChannelURLs)
+	}
+	if ch.TimeShift != "1" || ch.TimeShiftLength != 72*time.Hour {
+		t.Errorf("unexpected time shift: %s, %s", ch.TimeShift, ch.TimeShiftLength)
+	}
+	if ch.TimeShiftURL == nil || ch.TimeShiftURL.Host != server.Host() {
+		t.Errorf("unexpected time shift URL: %v", ch.TimeShiftURL)
+	}
+	if ch.Definition != iptv.DefinitionHD || !ch.FCCEnable || ch.FCCServer != "10.255.0.1:8027" || ch.ChannelSDP != "igmp://239.93.0.1:5140" {
+		t.Errorf("unexpected channel metadata: %+v", ch)
+	}
+	if ch.Properties["ChannelType"] != "1" || ch.Properties["ChannelFCCIP"] != "" {
+		t.Errorf("unexpected channel properties: %v", ch.Properties)
+	}
+	// 按清晰度分组
+	if ch.GroupName != "高清" || channels[1].Definition != iptv.DefinitionSD || channels[1].GroupName == "高清" {
+		t.Errorf("unexpected channel groups: %s, %s", ch.GroupName, channels[1].GroupName)
+	}
+	// 只有组播地址时，回看地址同时作为单播地址
+	if urls := channels[1].ChannelURLs; len(urls) != 2 || urls[1].Scheme != "http" {
+		t.Errorf("unexpected channel URLs: %v", urls)
+	}
+	// 字段顺序不同且缺少时移字段的频道
+	ch = channels[3]
+	if ch.ChannelID != "1005" || ch.ChannelName != "CGTN" || ch.UserChannelID != "20" ||
+		len(ch.ChannelURLs) != 1 || ch.TimeShift != "0" || ch.TimeShiftLength != 0 || ch.TimeShiftURL != nil {
+		t.Errorf("unexpected channel: %+v", ch)
+	}
+}
+
+func TestGetAllChannelListFormats(t *testing.T) {
+	server := newTestServer(t, "")
+	want, err := newTestClient(t, server, testKey, "").GetAllChannelList(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+
+	// 其他格式的频道列表页面解析出相同的频道
+	for _, channelList := range []string{"channellist_dq.html", "channellist_plain.html"} {
+		t.Run(channelList, func(t *testing.T) {
+			server := hwctctest.NewServer(hwctctest.Options{
+				Key:         testKey,
+				UserID:      testUserID,
+				ChannelList: channelList,
+			})
+			t.Cleanup(server.Close)
+
+			channels, err := newTestClient(t, server, testKey, "").GetAllChannelList(context.Background())
+			if err != nil {
+				t.Fatalf("GetAllChannelList() error = %v", err)
+			}
+			// 地址中的服务器端口不同，仅比较其余内容
+			if got, want := channelsWithoutHost(channels), channelsWithoutHost(want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got channels %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// channelsWithoutHost 去掉频道地址中的服务器地址，便于比较不同模拟服务返回的频道
+func channelsWithoutHost(channels []iptv.Channel) []iptv.Channel {
+	result := make([]iptv.Channel, 0, len(channels))
+	for _, ch := range channels {
+		urls := make([]url.URL, len(ch.ChannelURLs))
+		for i, u := range ch.ChannelURLs {
+			u.Host, u.RawQuery = "", ""
+			urls[i] = u
+		}
+		ch.ChannelURLs = urls
+		if ch.TimeShiftURL != nil {
+			u := *ch.TimeShiftURL
+			u.Host, u.RawQuery = "", ""
+			ch.TimeShiftURL = &u
+		}
+		result = append(result, ch)
+	}
+	return result
+}
+
+func TestGetAllChannelProgramList(t *testing.T) {
+	tests := []struct {
+		name              string
+		serverAPI         string
+		channelProgramAPI string
+	}{
+		{"liveplay_30", hwctctest.EPGAPILiveplay, hwctctest.EPGAPILiveplay},
+		{"gdhdpublic", hwctctest.EPGAPIGdhdpublic, hwctctest.EPGAPIGdhdpublic},
+		{"vsp", hwctctest.EPGAPIVsp, hwctctest.EPGAPIVsp},
+		{"StbEpg2023Group", hwctctest.EPGAPIStbEpg2023Group, hwctctest.EPGAPIStbEpg2023Group},
+		{"defaulttrans2", hwctctest.EPGAPIDefaulttrans2, hwctctest.EPGAPIDefaulttrans2},
+		{"auto", hwctctest.EPGAPIDefaulttrans2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.serverAPI)
+			client := newTestClient(t, server, testKey, tt.channelProgramAPI)
+
+			ctx := context.Background()
+			channels, err := client.GetAllChannelList(ctx)
+			if err != nil {
+				t.Fatalf("GetAllChannelList() error = %v", err)
+			}
+			epg, err := client.GetAllChannelProgramList(ctx, channels)
+			if err != nil {
+				t.Fatalf("GetAllChannelProgramList() error = %v", err)
+			}
+
+			// 只有支持回看的频道才有节目单
+			if len(epg) != 2 || epg[0].ChannelId != "1001" || epg[1].ChannelId != "1002" {
+				t.Fatalf("unexpected channel program lists: %d", len(epg))
+			}
+
+			now := time.Now()
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+			for _, progList := range epg {
+				var found bool
+				for _, dateProg := range progList.DateProgramList {
+					if !dateProg.Date.Equal(today) {
+						continue
+					}
+					found = true
+					if len(dateProg.ProgramList) != hwctctest.ProgramsPerDay {
+						t.Errorf("channel %s: got %d programs, want %d", progList.ChannelId, len(dateProg.ProgramList), hwctctest.ProgramsPerDay)
+					}
+					first := dateProg.ProgramList[0]
+					if want := hwctctest.ProgramName(progList.ChannelId, today, 0); first.ProgramName != want {
+						t.Errorf("channel %s: got program %q, want %q", progList.ChannelId, first.ProgramName, want)
+					}
+					if want := today.Format("20060102150405"); first.BeginTimeFormat != want {
+						t.Errorf("channel %s: got begin time %s, want %s", progList.ChannelId, first.BeginTimeFormat, want)
+					}
+					// 部分接口返回的可选信息
+					if tt.serverAPI == hwctctest.EPGAPIVsp && first.Rating != "G" {
+						t.Errorf("channel %s: got rating %q, want G", progList.ChannelId, first.Rating)
+					}
+					if tt.serverAPI == hwctctest.EPGAPIDefaulttrans2 && first.EpisodeNum != "第1集" {
+						t.Errorf("channel %s: got episode %q, want 第1集", progList.ChannelId, first.EpisodeNum)
+					}
+				}
+				if !found {
+					t.Errorf("channel %s: no programs for today", progList.ChannelId)
+				}
+			}
+
+			// 频道列表和节目单共用一次认证
+			if logins := server.Logins(); logins != 1 {
+				t.Errorf("got %d logins, want 1", logins)
+			}
+		})
+	}
+}
+
+func TestGetRecentChannelProgramList(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      string
+		allDates bool // 一次请求即返回全部日期的接口
+	}{
+		{"liveplay_30", hwctctest.EPGAPILiveplay, true},
+		{"gdhdpublic", hwctctest.EPGAPIGdhdpublic, false},
+		{"vsp", hwctctest.EPGAPIVsp, false},
+		{"StbEpg2023Group", hwctctest.EPGAPIStbEpg2023Group, false},
+		{"defaulttrans2", hwctctest.EPGAPIDefaulttrans2, false},
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.api)
+			client := newTestClient(t, server, testKey, tt.api)
+
+			ctx := context.Background()
+			channels, err := client.GetAllChannelList(ctx)
+			if err != nil {
+				t.Fatalf("GetAllChannelList() error = %v", err)
+			}
+			epg, err := client.(iptv.RecentProgramListProvider).GetRecentChannelProgramList(ctx, channels, 0)
+			if err != nil {
+				t.Fatalf("GetRecentChannelProgramList() error = %v", err)
+			}
+			if len(epg) != 2 {
+				t.Fatalf("got %d channel program lists, want 2", len(epg))
+			}
+
+			// 只获取当天及未来的节目单
+			for _, progList := range epg {
+				var found, before bool
+				for _, dateProg := range progList.DateProgramList {
+					found = found || dateProg.Date.Equal(today)
+					before = before || dateProg.Date.Before(today)
+				}
+				if !found {
+					t.Errorf("channel %s: no programs for today", progList.ChannelId)
+				}
+				if before != tt.allDates {
+					t.Errorf("channel %s: got programs before today = %v, want %v", progList.ChannelId, before, tt.allDates)
+				}
+			}
+		})
+	}
+}
+
+func TestEPGRateLimit(t *testing.T) {
+	server := newTestServer(t, hwctctest.EPGAPILiveplay)
+	config := newTestConfig(hwctctest.EPGAPILiveplay)
+	config.EPGRateLimit = 5
+	client := newTestClientWithConfig(t, &http.Client{}, server.Host(), testKey, config)
+
+	// 认证及获取频道列表的请求不限速
+	ctx := context.Background()
+	start := time.Now()
+	channels, err := client.GetAllChannelList(ctx)
+	if err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("GetAllChannelList() took %s, want no rate limit", elapsed)
+	}
+
+	// 获取2个频道的节目单，相邻两次请求至少间隔200ms
+	start = time.Now()
+	if _, err = client.GetAllChannelProgramList(ctx, channels); err != nil {
+		t.Fatalf("GetAllChannelProgramList() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("GetAllChannelProgramList() took %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, server, testKey, "")
+
+	ctx := context.Background()
+	if _, err := client.GetAllChannelList(ctx); err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+
+	// 会话失效后自动重新认证
+	server.ExpireSessions()
+	channels, err := client.GetAllChannelList(ctx)
+	if err != nil {
+		t.Fatalf("GetAllChannelList() after session expired error = %v", err)
+	}
+	if len(channels) != 6 {
+		t.Errorf("got %d channels, want 6", len(channels))
+	}
+	if logins := server.Logins(); logins != 2 {
+		t.Errorf("got %d logins, want 2", logins)
+	}
+}
+
+func TestLoadBalanceRedirect(t *testing.T) {
+	server := hwctctest.NewServer(hwctctest.Options{
+		Key:         testKey,
+		UserID:      testUserID,
+		LoadBalance: true,
+	})
+	t.Cleanup(server.Close)
+	client := newTestClient(t, server, testKey, "")
+
+	// 重定向至非登录或超时页面时不视为会话失效
+	ctx := context.Background()
+	channels, err := client.GetAllChannelList(ctx)
+	if err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+	if _, err = client.GetAllChannelProgramList(ctx, channels); err != nil {
+		t.Fatalf("GetAllChannelProgramList() error = %v", err)
+	}
+	if logins := server.Logins(); logins != 1 {
+		t.Errorf("got %d logins, want 1", logins)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	server := newTestServer(t, "")
+	client := newTestClient(t, server, "87654321", "")
+
+	if _, err := client.GetAllChannelList(context.Background()); err == nil {
+		t.Fatal("GetAllChannelList() with invalid key succeeded, want error")
+	}
+	if logins := server.Logins(); logins != 0 {
+		t.Errorf("got %d logins, want 0", logins)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := newTestServer(t, hwctctest.EPGAPIVsp)
+	dir := t.TempDir()
+	provider, err := iptv.GetProvider(hwctc.PlatformName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensitiveFields := provider.SensitiveFields
+
+	// 录制
+	recorder, err := capture.NewRecorder(dir, http.DefaultTransport, sensitiveFields)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	client := newTestClientWithHTTPClient(t, &http.Client{Transport: recorder}, server.Host(), testKey, hwctctest.EPGAPIVsp)
+	ctx := context.Background()
+	channels, err := client.GetAllChannelList(ctx)
+	if err != nil {
+		t.Fatalf("GetAllChannelList() error = %v", err)
+	}
+	epg, err := client.GetAllChannelProgramList(ctx, channels)
+	if err != nil {
+		t.Fatalf("GetAllChannelProgramList() error = %v", err)
+	}
+
+	// 录制内容中不能包含敏感信息
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) == 0 {
+		t.Fatal("no recordings found")
+	}
+	secrets := append(server.Secrets(), testSTBID, testUserID, "BC:62:02:A5:A7:A7", "BC%3A62%3A02%3AA5%3AA7%3AA7")
+	if len(secrets) != 7 {
+		t.Fatalf("got %d secrets, want 7", len(secrets))
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(string(content), secret) {
+				t.Errorf("%s contains sensitive value %s", filepath.Base(file), secret)
+			}
+		}
+	}
+
+	// 关闭服务器后回放
+	server.Close()
+	replayer, err := capture.NewReplayer(dir, sensitiveFields)
+	if err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+	client = newTestClientWithHTTPClient(t, &http.Client{Transport: replayer}, server.Host(), testKey, hwctctest.EPGAPIVsp)
+	replayedChannels, err := client.GetAllChannelList(ctx)
+	if err != nil {
+		t.Fatalf("GetAllChannelList() with replay error = %v", err)
+	}
+	if !reflect.DeepEqual(channels, replayedChannels) {
+		t.Errorf("replayed channels differ from the recorded ones")
+	}
+	replayedEPG, err := client.GetAllChannelProgramList(ctx, replayedChannels)
+	if err != nil {
+		t.Fatalf("GetAllChannelProgramList() with replay error = %v", err)
+	}
+	if !reflect.DeepEqual(epg, replayedEPG) {
+		t.Errorf("replayed EPG differs from the recorded one")
+	}
+}
@@ -0,0 +1,507 @@
+// Package hwctctest 提供模拟华为IPTV中间件的HTTP服务，用于在不连接运营商网络的情况下进行端到端测试。
+// 各接口的响应内容来自fixtures目录下的模板文件，节目单按请求的日期动态生成。
+package hwctctest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iptv/internal/app/iptv"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	EPGAPILiveplay        = "liveplay_30"
+	EPGAPIGdhdpublic      = "gdhdpublic"
+	EPGAPIVsp             = "vsp"
+	EPGAPIStbEpg2023Group = "StbEpg2023Group"
+	EPGAPIDefaulttrans2   = "defaulttrans2"
+
+	// ProgramsPerDay 每个频道每天生成的节目数量
+	ProgramsPerDay = 8
+	// 会话失效时重定向的页面
+	timeoutPath = "/EPG/jsp/timeout.jsp"
+	// 模拟负载均衡时重定向的路径前缀
+	loadBalancePrefix = "/lb"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+var templates = template.Must(template.ParseFS(fixtures, "fixtures/*"))
+
+// Options 模拟服务的配置
+type Options struct {
+	Key            string // 加密Authenticator的秘钥
+	UserID         string // 用户ID
+	STBID          string // 机顶盒ID
+	ProviderSuffix string // 供应商后缀，默认为CTC
+	EPGAPI         string // 支持的EPG接口，默认为liveplay_30，其他接口均返回404
+	TimeShiftDays  int    // 回看节目单的天数，默认为3天
+	LoadBalance    bool   // 模拟负载均衡，将会话内的请求以307重定向至/lb前缀下的相同路径
+	ChannelList    string // 频道列表页面的模板名称，默认为channellist.html
+}
+
+// Server 模拟的IPTV中间件服务
+type Server struct {
+	*httptest.Server
+
+	opts Options
+
+	mu       sync.Mutex
+	sessions map[string]string // JSESSIONID -> UserToken
+	tokens   map[string]bool   // 已下发的EncryptToken
+	logins   int               // 完成认证的次数
+	requests map[string]int    // 各路径的请求次数
+	secrets  []string          // 认证时下发及收到的敏感值
+}
+
+// NewServer 创建并启动模拟服务，使用完毕后需调用Close关闭
+func NewServer(opts Options) *Server {
+	if opts.ProviderSuffix == "" {
+		opts.ProviderSuffix = "CTC"
+	}
+	if opts.EPGAPI == "" {
+		opts.EPGAPI = EPGAPILiveplay
+	}
+	if opts.TimeShiftDays <= 0 {
+		opts.TimeShiftDays = 3
+	}
+	if opts.ChannelList == "" {
+		opts.ChannelList = "channellist.html"
+	}
+
+	s := &Server{
+		opts:     opts,
+		sessions: make(map[string]string),
+		tokens:   make(map[string]bool),
+		requests: make(map[string]int),
+	}
+
+	suffix := opts.ProviderSuffix
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /EDS/jsp/AuthenticationURL", s.handleEDSAuthenticationURL)
+	mux.HandleFunc("GET /EPG/jsp/AuthenticationURL", s.handleAuthenticationURL)
+	mux.HandleFunc("POST /EPG/jsp/authLoginHW"+suffix+".jsp", s.handleAuthLogin)
+	mux.HandleFunc("POST /EPG/jsp/ValidAuthenticationHW"+suffix+".jsp", s.handleValidAuthentication)
+	mux.HandleFunc("POST /EPG/jsp/getchannellistHW"+suffix+".jsp", s.withSession(s.handleChannelList))
+	mux.HandleFunc(timeoutPath, s.handleTimeout)
+	mux.HandleFunc("GET /PLTV/", s.handleTimeShift)
+
+	switch opts.EPGAPI {
+	case EPGAPILiveplay:
+		mux.HandleFunc("GET /EPG/jsp/liveplay_30/en/getTvodData.jsp", s.withSession(s.handleLiveplay))
+	case EPGAPIGdhdpublic:
+		mux.HandleFunc("GET /EPG/jsp/gdhdpublic/Ver.3/common/data.jsp", s.withSession(s.handleGdhdpublic))
+	case EPGAPIVsp:
+		mux.HandleFunc("POST /VSP/V3/QueryPlaybillList", s.withSession(s.handleVsp))
+	case EPGAPIStbEpg2023Group:
+		mux.HandleFunc("POST /EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getProperties.jsp", s.withSession(s.handleStbEpgProperties))
+		mux.HandleFunc("POST /EPG/jsp/StbEpg2023Group/en/function/ajax/epg7getChannelByAjax.jsp", s.withSession(s.handleStbEpgChannel))
+	case EPGAPIDefaulttrans2:
+		mux.HandleFunc("GET /EPG/jsp/defaulttrans2/en/datajsp/getTvodProgListByIndex.jsp", s.withSession(s.handleDefaulttrans2))
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		if path, ok := strings.CutPrefix(r.URL.Path, loadBalancePrefix+"/"); ok && opts.LoadBalance {
+			r.URL.Path = "/" + path
+			r = r.WithContext(context.WithValue(r.Context(), balancedKey{}, true))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Host 服务的地址和端口，用于配置IPTV客户端的serverHost
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Logins 完成认证的次数
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Requests 指定路径的请求次数
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// Secrets 认证时下发及收到的敏感值：JSESSIONID、UserToken及Authenticator
+func (s *Server) Secrets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.secrets)
+}
+
+// ExpireSessions 使所有已登录的会话失效，模拟运营商的会话超时
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.sessions)
+}
+
+// ProgramName 生成的节目名称，index为节目在当天的序号（从0开始）
+func ProgramName(channelID string, date time.Time, index int) string {
+	return fmt.Sprintf("频道%s节目%s-%d", channelID, date.Format("0102"), index+1)
+}
+
+// handleEDSAuthenticationURL 认证入口，重定向至EPG服务器
+func (s *Server) handleEDSAuthenticationURL(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/EPG/jsp/AuthenticationURL?"+r.URL.RawQuery, http.StatusFound)
+}
+
+func (s *Server) handleAuthenticationURL(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("UserID") != s.opts.UserID {
+		http.Error(w, "unknown user", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	_, _ = w.Write([]byte("<html><body>AuthenticationURL</body></html>"))
+}
+
+// handleAuthLogin 下发EncryptToken
+func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("UserID") != s.opts.UserID {
+		http.Error(w, "unknown user", http.StatusForbidden)
+		return
+	}
+
+	encryptToken := randomHex(16)
+	s.mu.Lock()
+	s.tokens[encryptToken] = true
+	s.mu.Unlock()
+
+	s.render(w, "authLogin.html", map[string]any{"EncryptToken": encryptToken})
+}
+
+// handleValidAuthentication 校验Authenticator，下发UserToken和JSESSIONID
+func (s *Server) handleValidAuthentication(w http.ResponseWriter, r *http.Request) {
+	encryptToken := r.PostFormValue("userToken")
+	s.mu.Lock()
+	validToken := s.tokens[encryptToken]
+	delete(s.tokens, encryptToken)
+	s.mu.Unlock()
+	if !validToken {
+		http.Error(w, "invalid encrypt token", http.StatusForbidden)
+		return
+	}
+
+	// 输入的格式：random$EncryptToken$UserID$STBID$IP$MAC$Reserved$CTC
+	plain, err := iptv.NewTripleDESCrypto(s.opts.Key).ECBDecrypt(strings.ToLower(r.PostFormValue("Authenticator")))
+	if err != nil {
+		http.Error(w, "invalid authenticator", http.StatusForbidden)
+		return
+	}
+	fields := strings.Split(plain, "$")
+	if len(fields) != 8 || fields[1] != encryptToken || fields[2] != s.opts.UserID || fields[3] != r.PostFormValue("STBID") {
+		http.Error(w, "invalid authenticator", http.StatusForbidden)
+		return
+	}
+
+	jsessionID := strings.ToUpper(randomHex(16))
+	userToken := randomHex(12)
+	s.mu.Lock()
+	s.sessions[jsessionID] = userToken
+	s.logins++
+	s.secrets = append(s.secrets, jsessionID, userToken, r.PostFormValue("Authenticator"))
+	s.mu.Unlock()
+
+	stbID := s.opts.STBID
+	if stbID == "" {
+		stbID = r.PostFormValue("STBID")
+	}
+	http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: jsessionID, Path: "/"})
+	s.render(w, "validAuthentication.html", map[string]any{
+		"Host":      r.Host,
+		"UserToken": userToken,
+		"UserID":    s.opts.UserID,
+		"STBID":     stbID,
+	})
+}
+
+// balancedKey 请求已经过负载均衡重定向的context key
+type balancedKey struct{}
+
+// withSession 校验会话，会话失效时重定向至超时页面
+func (s *Server) withSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.LoadBalance && r.Context().Value(balancedKey{}) == nil {
+			http.Redirect(w, r, loadBalancePrefix+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		cookie, err := r.Cookie("JSESSIONID")
+		if err == nil {
+			s.mu.Lock()
+			_, ok := s.sessions[cookie.Value]
+			s.mu.Unlock()
+			if ok {
+				next(w, r)
+				return
+			}
+		}
+		http.Redirect(w, r, timeoutPath, http.StatusFound)
+	}
+}
+
+func (s *Server) handleTimeout(w http.ResponseWriter, _ *http.Request) {
+	s.render(w, "timeout.html", nil)
+}
+
+func (s *Server) handleChannelList(w http.ResponseWriter, r *http.Request) {
+	cookie, _ := r.Cookie("JSESSIONID")
+	s.mu.Lock()
+	userToken := s.sessions[cookie.Value]
+	s.mu.Unlock()
+	if r.PostFormValue("UserToken") != userToken {
+		s.render(w, "timeout.html", nil)
+		return
+	}
+
+	s.render(w, s.opts.ChannelList, map[string]any{"Host": r.Host})
+}
+
+// handleTimeShift 回看地址，需携带有效的JSESSIONID，返回HLS播放列表、密钥及分片
+func (s *Server) handleTimeShift(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("JSESSIONID")
+	if err != nil {
+		http.Error(w, "no session", http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	_, ok := s.sessions[cookie.Value]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "invalid session", http.StatusForbidden)
+		return
+	}
+
+	// 分片及密钥直接返回文件名，便于校验
+	switch path.Ext(r.URL.Path) {
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+		_, _ = w.Write([]byte(path.Base(r.URL.Path)))
+		return
+	case ".key":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte(path.Base(r.URL.Path)))
+		return
+	}
+
+	// index.m3u8为多码率的主播放列表，其他为媒体播放列表
+	name := "timeshift_media.m3u8"
+	if path.Base(r.URL.Path) == "index.m3u8" {
+		name = "timeshift.m3u8"
+	}
+	var buf bytes.Buffer
+	if err = templates.ExecuteTemplate(&buf, name, map[string]any{"Begin": r.URL.Query().Get("playseek")}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Server) handleLiveplay(w http.ResponseWriter, r *http.Request) {
+	channelID := r.URL.Query().Get("channelId")
+	// 返回从回看最早日期至明天的节目单
+	today := truncateToDay(time.Now())
+	days := make([]fixtureDay, 0, s.opts.TimeShiftDays+2)
+	for i := -s.opts.TimeShiftDays; i <= 1; i++ {
+		days = append(days, newFixtureDay(channelID, today.AddDate(0, 0, i)))
+	}
+
+	s.render(w, "liveplay.html", map[string]any{"ChannelID": channelID, "Days": days})
+}
+
+func (s *Server) handleGdhdpublic(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("Action") != "channelProgramList" {
+		http.NotFound(w, r)
+		return
+	}
+	date, err := time.ParseInLocation("20060102", query.Get("date"), time.Local)
+	if err != nil {
+		http.Error(w, "invalid date", http.StatusBadRequest)
+		return
+	}
+	channelID := query.Get("channelId")
+
+	s.render(w, "gdhdpublic.json", map[string]any{"ChannelID": channelID, "Day": newFixtureDay(channelID, date)})
+}
+
+func (s *Server) handleVsp(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		QueryChannel struct {
+			ChannelIDs []int64 `json:"channelIDs"`
+		} `json:"queryChannel"`
+		QueryPlaybill struct {
+			StartTime string `json:"startTime"`
+		} `json:"queryPlaybill"`
+	}
+	// VSP接口的Content-Type并不是application/json，直接解析请求体
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.QueryChannel.ChannelIDs) == 0 {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	startTime, err := strconv.ParseInt(payload.QueryPlaybill.StartTime, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid startTime", http.StatusBadRequest)
+		return
+	}
+	channelID := strconv.FormatInt(payload.QueryChannel.ChannelIDs[0], 10)
+
+	s.render(w, "vsp.json", map[string]any{
+		"ChannelID": channelID,
+		"Day":       newFixtureDay(channelID, truncateToDay(time.UnixMilli(startTime))),
+	})
+}
+
+func (s *Server) handleStbEpgProperties(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("action") != "getChannelCate" {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "stbepg_category.json", nil)
+}
+
+func (s *Server) handleStbEpgChannel(w http.ResponseWriter, r *http.Request) {
+	switch r.PostFormValue("action") {
+	case "getChannelList":
+		s.render(w, "stbepg_channels.json", map[string]any{"Channels": []string{"1001", "1002", "1003", "1004"}})
+	case "getChannelProg":
+		channelID := r.PostFormValue("channelID")
+		if r.PostFormValue("code") != "code"+channelID {
+			http.Error(w, "invalid channel code", http.StatusBadRequest)
+			return
+		}
+		startTime, err1 := strconv.ParseInt(r.PostFormValue("startTime"), 10, 64)
+		endTime, err2 := strconv.ParseInt(r.PostFormValue("endTime"), 10, 64)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "invalid time range", http.StatusBadRequest)
+			return
+		}
+
+		var days []fixtureDay
+		for date := truncateToDay(time.UnixMilli(startTime)); date.UnixMilli() < endTime; date = date.AddDate(0, 0, 1) {
+			days = append(days, newFixtureDay(channelID, date))
+		}
+		s.render(w, "stbepg_programs.json", map[string]any{"ChannelID": channelID, "Days": days})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleDefaulttrans2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	index, err := strconv.Atoi(query.Get("index"))
+	if err != nil || index > 0 || index < -6 {
+		http.Error(w, "invalid index", http.StatusBadRequest)
+		return
+	}
+	channelID := query.Get("CHANNELID")
+
+	// 日期标题为最近7天，最后一个为当天
+	today := truncateToDay(time.Now())
+	titles := make([]string, 0, 7)
+	for i := -6; i <= 0; i++ {
+		titles = append(titles, today.AddDate(0, 0, i).Format("02")+"日")
+	}
+
+	s.render(w, "defaulttrans2.json", map[string]any{
+		"Day":    newFixtureDay(channelID, today.AddDate(0, 0, index)),
+		"Titles": titles,
+	})
+}
+
+// render 使用模板渲染响应内容
+func (s *Server) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "text/html; charset=UTF-8"
+	if strings.HasSuffix(name, ".json") {
+		contentType = "application/json; charset=UTF-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// fixtureProgram 模板中使用的节目信息
+type fixtureProgram struct {
+	ID         string
+	Name       string
+	SubName    string
+	Begin      string // yyyyMMddHHmmss
+	End        string // yyyyMMddHHmmss
+	StartTime  string // HH:mm
+	EndTime    string // HH:mm
+	BeginMilli int64
+	EndMilli   int64
+}
+
+// fixtureDay 模板中使用的某一天的节目单
+type fixtureDay struct {
+	Date     time.Time
+	DateDash string // yyyy-MM-dd
+	Programs []fixtureProgram
+}
+
+// newFixtureDay 生成指定频道某一天的节目单，节目时长均为3小时
+func newFixtureDay(channelID string, date time.Time) fixtureDay {
+	duration := 24 * time.Hour / ProgramsPerDay
+	programs := make([]fixtureProgram, 0, ProgramsPerDay)
+	for i := range ProgramsPerDay {
+		begin := date.Add(time.Duration(i) * duration)
+		end := begin.Add(duration)
+		programs = append(programs, fixtureProgram{
+			ID:         fmt.Sprintf("%s%s%02d", channelID, date.Format("20060102"), i),
+			Name:       ProgramName(channelID, date, i),
+			SubName:    fmt.Sprintf("第%d集", i+1),
+			Begin:      begin.Format("20060102150405"),
+			End:        end.Format("20060102150405"),
+			StartTime:  begin.Format("15:04"),
+			EndTime:    end.Format("15:04"),
+			BeginMilli: begin.UnixMilli(),
+			EndMilli:   end.UnixMilli(),
+		})
+	}
+	return fixtureDay{
+		Date:     date,
+		DateDash: date.Format(time.DateOnly),
+		Programs: programs,
+	}
+}
+
+// truncateToDay 时间取整到天
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
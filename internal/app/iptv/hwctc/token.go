@@ -0,0 +1,108 @@
+package hwctc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var ErrSessionExpired = errors.New("the session has expired")
+
+// sessionExpiredRegex 会话失效时服务器返回页面中的特征内容
+var sessionExpiredRegex = regexp.MustCompile("(?i)(session\\s*time\\s*out|timeout\\.jsp|relogin|重新登录|会话超时|登录超时)")
+
+// sessionExpiredPathRegex 会话失效时重定向的登录或超时页面的路径
+var sessionExpiredPathRegex = regexp.MustCompile("(?i)/[^/]*(timeout|login|logout|authentication)[^/]*$")
+
+// getToken 获取认证的Token，优先使用缓存且尚未过期的Token
+func (c *Client) getToken(ctx context.Context) (*Token, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != nil {
+		age := time.Since(c.tokenTime)
+		// 未观测到有效期时一直复用，直至请求返回会话失效；否则提前10%的时间主动刷新
+		if c.tokenLifetime <= 0 || age < c.tokenLifetime-c.tokenLifetime/10 {
+			return c.token, nil
+		}
+		c.logger.Sugar().Infof("The token is about to expire, re-authenticate. Age: %s, observed lifetime: %s.", age, c.tokenLifetime)
+	}
+
+	// 重新请求认证的Token
+	token, err := c.requestToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.token = token
+	c.tokenTime = time.Now()
+	return token, nil
+}
+
+// SessionCookies 获取访问运营商资源（如回看地址）时所需的会话Cookie
+func (c *Client) SessionCookies(ctx context.Context) ([]*http.Cookie, error) {
+	token, err := c.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []*http.Cookie{
+		{Name: "JSESSIONID", Value: token.JSESSIONID},
+	}, nil
+}
+
+// invalidateToken 使缓存的Token失效，并记录观测到的Token有效期
+func (c *Client) invalidateToken(token *Token) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	// 缓存的Token可能已经被其他请求刷新过了
+	if c.token == nil || c.token != token {
+		return
+	}
+
+	lifetime := time.Since(c.tokenTime)
+	if c.tokenLifetime <= 0 || lifetime < c.tokenLifetime {
+		c.tokenLifetime = lifetime
+	}
+	c.token = nil
+}
+
+// doWithToken 使用缓存的Token执行请求，若会话已失效则重新认证并重试一次
+func (c *Client) doWithToken(ctx context.Context, fn func(token *Token) error) error {
+	token, err := c.getToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(token)
+	if !errors.Is(err, ErrSessionExpired) {
+		return err
+	}
+
+	c.logger.Info("The session has expired, re-authenticate and try again.")
+	c.invalidateToken(token)
+	token, err = c.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(token)
+}
+
+// checkSessionExpired 检查响应是否表明会话已失效
+func checkSessionExpired(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrSessionExpired
+	}
+	// 会话失效时，服务器通常会重定向至登录或超时页面；负载均衡等其他重定向不视为会话失效
+	if resp.Request != nil && resp.Request.URL.Path != req.URL.Path &&
+		sessionExpiredPathRegex.MatchString(resp.Request.URL.Path) {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// isSessionExpiredPage 判断响应页面是否为会话失效的提示页面
+func isSessionExpiredPage(body []byte) bool {
+	return sessionExpiredRegex.Match(body)
+}
@@ -11,3 +11,20 @@ type Client interface {
 	// GetAllChannelProgramList 获取所有频道的节目单列表
 	GetAllChannelProgramList(ctx context.Context, channels []Channel) ([]ChannelProgramList, error)
 }
+
+// RecentProgramListProvider 可选接口，只获取最近几天的节目单，用于频繁地刷新当天的节目单，避免每次都查询全部的回看日期
+type RecentProgramListProvider interface {
+	// GetRecentChannelProgramList 获取所有频道最近backDays天（为0时只获取当天）及未来的节目单列表
+	GetRecentChannelProgramList(ctx context.Context, channels []Channel, backDays int) ([]ChannelProgramList, error)
+}
+
+// StatusProvider 可选接口，提供IPTV客户端的运行状态
+type StatusProvider interface {
+	Status() ClientStatus
+}
+
+// ClientStatus IPTV客户端的运行状态
+type ClientStatus struct {
+	Host              string `json:"host"`              // 当前使用的（重定向后的）服务器地址和端口
+	ChannelProgramAPI string `json:"channelProgramAPI"` // 当前使用的（自动选择的）EPG接口，尚未选择时为空
+}
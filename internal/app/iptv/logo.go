@@ -1,6 +1,10 @@
 package iptv
 
 import (
+	"iptv/internal/pkg/util"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,7 +18,12 @@ type ChannelLogoRule struct {
 }
 
 func (l *ChannelLogoRule) ResolveName(matches []string) string {
-	s := l.Name
+	return expandGroups(l.Name, matches)
+}
+
+// expandGroups 将模板中的$G1, $G2等替换为正则表达式的对应分组
+func expandGroups(template string, matches []string) string {
+	s := template
 	if len(matches) > 1 {
 		for i, ma := range matches[1:] {
 			s = strings.ReplaceAll(s, "$G"+strconv.FormatInt(int64(i+1), 10), ma)
@@ -33,3 +42,25 @@ func GetChannelLogoName(chLogoRuleList []ChannelLogoRule, channelName string) st
 	}
 	return channelName
 }
+
+// GetChannelLogoURL 获取频道台标的URL地址，台标图片不存在时返回空
+func GetChannelLogoURL(logoBaseUrl, logoName string) string {
+	if logoBaseUrl == "" || logoName == "" {
+		return ""
+	}
+
+	currDir, err := util.GetCurrentAbPathByExecutable()
+	if err != nil {
+		return ""
+	}
+
+	logoFile := logoName + ".png"
+	if _, err = os.Stat(filepath.Join(currDir, logoDirName, logoFile)); os.IsNotExist(err) {
+		return ""
+	}
+	logoUrl, err := url.JoinPath(logoBaseUrl, logoFile)
+	if err != nil {
+		return ""
+	}
+	return logoUrl
+}
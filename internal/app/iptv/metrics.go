@@ -0,0 +1,53 @@
+package iptv
+
+import (
+	"iptv/internal/pkg/metrics"
+	"strconv"
+	"time"
+)
+
+// 刷新阶段
+const (
+	StageAuth        = "auth"
+	StageChannelList = "channel_list"
+	StageEPG         = "epg"
+)
+
+// 刷新结果
+const (
+	ResultSuccess  = "success"
+	ResultError    = "error"
+	ResultNotFound = "not_found" // EPG接口不存在
+)
+
+var (
+	refreshDuration = metrics.NewHistogramVec("iptv_refresh_duration_seconds",
+		"Duration of refresh stages against the IPTV platform.",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}, "stage", "strategy")
+	refreshTotal = metrics.NewCounterVec("iptv_refresh_total",
+		"Number of refresh stages against the IPTV platform by result.", "stage", "strategy", "result")
+	epgChannelFailures = metrics.NewCounterVec("iptv_epg_channel_failures_total",
+		"Number of failures to fetch the program list of a channel.", "channel_id", "channel_name")
+	upstreamRequests = metrics.NewCounterVec("iptv_upstream_requests_total",
+		"Number of HTTP requests to the IPTV platform by endpoint and status code.", "endpoint", "code")
+)
+
+// ObserveRefresh 记录刷新阶段（认证、频道列表、各个EPG接口）的耗时及结果，strategy为EPG接口的名称
+func ObserveRefresh(stage, strategy string, start time.Time, result string) {
+	refreshDuration.Observe(time.Since(start).Seconds(), stage, strategy)
+	refreshTotal.Inc(stage, strategy, result)
+}
+
+// ObserveEPGChannelFailure 记录获取频道节目单失败的次数
+func ObserveEPGChannelFailure(channel *Channel) {
+	epgChannelFailures.Inc(channel.ChannelID, channel.ChannelName)
+}
+
+// ObserveUpstreamRequest 记录对IPTV平台的HTTP请求，请求失败（无响应）时statusCode为0
+func ObserveUpstreamRequest(endpoint string, statusCode int) {
+	code := "error"
+	if statusCode > 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	upstreamRequests.Inc(endpoint, code)
+}
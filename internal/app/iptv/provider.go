@@ -0,0 +1,83 @@
+package iptv
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ClientOptions 创建IPTV客户端时与平台无关的通用参数
+type ClientOptions struct {
+	HTTPClient       *http.Client        // HTTP客户端
+	Key              string              // 加密Authenticator的秘钥
+	ServerHost       string              // HTTP请求的服务器地址端口
+	Headers          map[string]string   // 自定义HTTP请求头
+	ChGroupRulesList []ChannelGroupRules // 频道分组的规则
+	ChLogoRuleList   []ChannelLogoRule   // 频道台标的匹配规则
+}
+
+// ConfigDecoder 将平台相关的配置内容解析到指定的结构体中
+type ConfigDecoder func(v any) error
+
+// Provider IPTV平台（中间件类型）
+type Provider struct {
+	Name string // 平台名称，对应配置文件中的platform字段及平台配置的名称
+	// SensitiveFields 请求参数及响应页面中的敏感字段名称，录制运营商的HTTP交互时需要脱敏
+	SensitiveFields []string
+
+	// DefaultConfig 返回平台的缺省配置，用于生成缺省配置文件
+	DefaultConfig func() any
+	// NewClient 解析并校验平台相关的配置，然后创建IPTV客户端
+	NewClient func(decode ConfigDecoder, opts *ClientOptions) (Client, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]*Provider)
+)
+
+// RegisterProvider 注册IPTV平台，一般在平台实现包的init函数中调用
+func RegisterProvider(p *Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if p == nil || p.Name == "" || p.NewClient == nil {
+		panic("iptv: invalid provider")
+	}
+	if _, ok := providers[p.Name]; ok {
+		panic("iptv: provider " + p.Name + " already registered")
+	}
+	providers[p.Name] = p
+}
+
+// GetProvider 获取指定名称的IPTV平台
+func GetProvider(name string) (*Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported platform: %s, available platforms: %v", name, providerNames())
+	}
+	return p, nil
+}
+
+// NewClient 根据平台名称创建对应的IPTV客户端
+func NewClient(platform string, decode ConfigDecoder, opts *ClientOptions) (Client, error) {
+	p, err := GetProvider(platform)
+	if err != nil {
+		return nil, err
+	}
+	return p.NewClient(decode, opts)
+}
+
+// providerNames 获取所有已注册的平台名称
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,277 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// 单个UDP数据包的最大长度
+	maxPacketSize = 65536
+	// 每个客户端缓冲的数据包数量，超出时丢弃数据包
+	clientQueueSize = 1024
+	// 组播socket的接收缓冲区大小
+	readBufferSize = 4 * 1024 * 1024
+	// 缺省的无数据超时时间
+	defaultTimeout = 5 * time.Second
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid multicast address")
+	ErrNoData         = errors.New("no data received from multicast group")
+)
+
+// Relay 组播转HTTP单播服务，同一个组播地址的多个客户端共享一个组播订阅
+type Relay struct {
+	iface   *net.Interface // 接收组播的网络接口，为nil时由系统选择
+	timeout time.Duration  // 无数据超时时间
+
+	mu     sync.Mutex
+	groups map[string]*group // 已加入的组播组
+
+	logger *zap.Logger
+}
+
+// NewRelay 创建组播转发服务
+func NewRelay(interfaceName string, timeout time.Duration) (*Relay, error) {
+	var iface *net.Interface
+	if interfaceName != "" {
+		var err error
+		if iface, err = net.InterfaceByName(interfaceName); err != nil {
+			return nil, err
+		}
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Relay{
+		iface:   iface,
+		timeout: timeout,
+		groups:  make(map[string]*group),
+		logger:  zap.L(),
+	}, nil
+}
+
+// Serve 加入指定的组播组，并将接收到的数据通过HTTP响应持续输出，直至客户端断开连接
+// stripRTP为true时会去掉RTP头，仅输出MPEG-TS数据
+func (r *Relay) Serve(w http.ResponseWriter, req *http.Request, addr string, stripRTP bool) error {
+	gAddr, err := parseMulticastAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	// 订阅组播组
+	g, cl, err := r.subscribe(gAddr)
+	if err != nil {
+		return err
+	}
+	defer r.unsubscribe(g, cl)
+
+	ctx := req.Context()
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.done:
+			return g.err
+		case <-timer.C:
+			if !started {
+				return ErrNoData
+			}
+			r.logger.Warn("No data received from the multicast group, stop streaming.", zap.String("addr", g.key))
+			return nil
+		case pkt := <-cl.queue:
+			timer.Reset(r.timeout)
+
+			payload := pkt
+			if stripRTP {
+				payload = stripRTPHeader(pkt)
+			}
+			if len(payload) == 0 {
+				continue
+			}
+
+			if !started {
+				w.Header().Set("Content-Type", "video/mp2t")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.WriteHeader(http.StatusOK)
+				started = true
+			}
+			if _, err = w.Write(payload); err != nil {
+				return nil
+			}
+			// 客户端队列为空时再刷新，以减少系统调用
+			if flusher != nil && len(cl.queue) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// subscribe 订阅组播组，若该组播组尚未加入则加入
+func (r *Relay) subscribe(gAddr *net.UDPAddr) (*group, *client, error) {
+	key := gAddr.String()
+	cl := &client{
+		queue: make(chan []byte, clientQueueSize),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[key]
+	if ok {
+		// 接收已异常结束的组播组需要重新加入
+		select {
+		case <-g.done:
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		conn, err := net.ListenMulticastUDP("udp4", r.iface, gAddr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to join multicast group %s: %w", key, err)
+		}
+		_ = conn.SetReadBuffer(readBufferSize)
+
+		// socket绑定的是0.0.0.0:port，会收到本机已加入的相同端口的其他组播组的数据，需按目的地址过滤
+		pconn := ipv4.NewPacketConn(conn)
+		if err = pconn.SetControlMessage(ipv4.FlagDst, true); err != nil {
+			r.logger.Warn("Failed to enable the destination address of multicast packets.", zap.String("addr", key), zap.Error(err))
+		}
+
+		g = &group{
+			key:     key,
+			ip:      gAddr.IP,
+			conn:    conn,
+			pconn:   pconn,
+			clients: make(map[*client]struct{}),
+			done:    make(chan struct{}),
+		}
+		r.groups[key] = g
+		go g.receive()
+
+		r.logger.Info("Joined the multicast group.", zap.String("addr", key))
+	}
+	g.addClient(cl)
+	return g, cl, nil
+}
+
+// unsubscribe 取消订阅，最后一个客户端离开时退出组播组
+func (r *Relay) unsubscribe(g *group, cl *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g.removeClient(cl) > 0 {
+		return
+	}
+	if r.groups[g.key] == g {
+		delete(r.groups, g.key)
+	}
+	_ = g.conn.Close()
+
+	r.logger.Info("Left the multicast group.", zap.String("addr", g.key))
+}
+
+// Close 关闭所有组播订阅
+func (r *Relay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, g := range r.groups {
+		_ = g.conn.Close()
+		delete(r.groups, key)
+	}
+}
+
+// client 订阅组播数据的客户端
+type client struct {
+	queue chan []byte
+}
+
+// group 已加入的组播组
+type group struct {
+	key   string
+	ip    net.IP
+	conn  *net.UDPConn
+	pconn *ipv4.PacketConn
+
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+
+	done chan struct{} // 接收结束时关闭
+	err  error         // 接收结束的原因
+}
+
+func (g *group) addClient(cl *client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.clients[cl] = struct{}{}
+}
+
+// removeClient 移除客户端，返回剩余的客户端数量
+func (g *group) removeClient(cl *client) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, cl)
+	return len(g.clients)
+}
+
+// receive 接收组播数据并分发给所有客户端
+func (g *group) receive() {
+	defer close(g.done)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, cm, _, err := g.pconn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				g.err = err
+			}
+			return
+		}
+		// 丢弃发往其他组播组的数据包
+		if cm != nil && cm.Dst != nil && !cm.Dst.Equal(g.ip) {
+			continue
+		}
+
+		pkt := make([]byte, n)
+		copy(pkt, buf[:n])
+
+		g.mu.RLock()
+		for cl := range g.clients {
+			select {
+			case cl.queue <- pkt:
+			default:
+				// 客户端消费过慢，丢弃该数据包
+			}
+		}
+		g.mu.RUnlock()
+	}
+}
+
+// parseMulticastAddr 解析组播地址，格式为 ip:port
+func parseMulticastAddr(addr string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, addr)
+	}
+	gAddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, port))
+	if err != nil || gAddr.Port == 0 || !gAddr.IP.IsMulticast() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, addr)
+	}
+	return gAddr, nil
+}
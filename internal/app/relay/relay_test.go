@@ -0,0 +1,247 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testGroupAddr = "239.255.42.1:51234"
+
+// startSender 持续向组播组发送RTP数据包，直至测试结束；当前环境不支持组播时跳过测试
+func startSender(t *testing.T, addr string, payload []byte) {
+	t.Helper()
+
+	gAddr, err := parseMulticastAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.ListenMulticastUDP("udp4", nil, gAddr)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	_ = listener.Close()
+	conn, err := net.DialUDP("udp4", nil, gAddr)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		_ = conn.Close()
+	})
+
+	pkt := rtpPacket(payload, 1, -1, 0)
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_, _ = conn.Write(pkt)
+			}
+		}
+	}()
+}
+
+// newTestRelay 创建组播转发服务及对应的HTTP服务，stripRTP为true时去掉RTP头
+func newTestRelay(t *testing.T, timeout time.Duration, stripRTP bool) (*Relay, *httptest.Server) {
+	t.Helper()
+
+	r, err := NewRelay("", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := r.Serve(w, req, req.URL.Query().Get("addr"), stripRTP)
+		switch {
+		case errors.Is(err, ErrInvalidAddress):
+			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, ErrNoData):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		r.Close()
+	})
+	return r, server
+}
+
+// openStream 请求组播流，返回响应及用于断开连接的函数
+func openStream(t *testing.T, server *httptest.Server, addr string) (*http.Response, context.CancelFunc) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?addr="+addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		_ = resp.Body.Close()
+	})
+	return resp, cancel
+}
+
+// clientCount 获取组播组的客户端数量，组播组不存在时返回-1
+func (r *Relay) clientCount(addr string) int {
+	r.mu.Lock()
+	g, ok := r.groups[addr]
+	r.mu.Unlock()
+	if !ok {
+		return -1
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.clients)
+}
+
+// waitClientCount 等待组播组的客户端数量变为want
+func waitClientCount(t *testing.T, r *Relay, addr string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := r.clientCount(addr)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d clients of %s, want %d", got, addr, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelayFanOut(t *testing.T) {
+	payload := tsPayload(188)
+	startSender(t, testGroupAddr, payload)
+	r, server := newTestRelay(t, time.Second, true)
+
+	// 同一组播地址的多个客户端共享一个组播订阅
+	resp1, cancel1 := openStream(t, server, testGroupAddr)
+	resp2, cancel2 := openStream(t, server, testGroupAddr)
+	for i, resp := range []*http.Response{resp1, resp2} {
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("client %d got status %d, want %d", i+1, resp.StatusCode, http.StatusOK)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "video/mp2t" {
+			t.Errorf("client %d got Content-Type %q, want video/mp2t", i+1, contentType)
+		}
+
+		// 每个客户端均收到去掉RTP头的完整数据
+		buf := make([]byte, 2*len(payload))
+		if _, err := io.ReadFull(bufio.NewReader(resp.Body), buf); err != nil {
+			t.Fatalf("client %d read error = %v", i+1, err)
+		}
+		if !bytes.Equal(buf[:len(payload)], payload) || !bytes.Equal(buf[len(payload):], payload) {
+			t.Errorf("client %d got unexpected payload %x", i+1, buf[:16])
+		}
+	}
+	waitClientCount(t, r, testGroupAddr, 2)
+
+	// 客户端断开连接后取消订阅，最后一个客户端离开时退出组播组
+	cancel1()
+	waitClientCount(t, r, testGroupAddr, 1)
+	cancel2()
+	waitClientCount(t, r, testGroupAddr, -1)
+
+	// 退出后可以重新加入
+	resp3, _ := openStream(t, server, testGroupAddr)
+	if resp3.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d after rejoining, want %d", resp3.StatusCode, http.StatusOK)
+	}
+	waitClientCount(t, r, testGroupAddr, 1)
+}
+
+func TestRelaySamePort(t *testing.T) {
+	// 运营商的组播组通常使用相同的端口，不同组播组的数据不能相互混入
+	addrs := []string{"239.255.42.4:51235", "239.255.42.5:51235"}
+	payloads := make([][]byte, len(addrs))
+	for i, addr := range addrs {
+		payloads[i] = tsPayload(188)
+		payloads[i][1] = byte(i + 1)
+		startSender(t, addr, payloads[i])
+	}
+	_, server := newTestRelay(t, time.Second, true)
+
+	resps := make([]*http.Response, len(addrs))
+	for i, addr := range addrs {
+		resps[i], _ = openStream(t, server, addr)
+		if resps[i].StatusCode != http.StatusOK {
+			t.Fatalf("%s got status %d, want %d", addr, resps[i].StatusCode, http.StatusOK)
+		}
+	}
+	for i, resp := range resps {
+		reader := bufio.NewReader(resp.Body)
+		buf := make([]byte, len(payloads[i]))
+		for range 20 {
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				t.Fatalf("%s read error = %v", addrs[i], err)
+			}
+			if !bytes.Equal(buf, payloads[i]) {
+				t.Fatalf("%s got payload of group %d, want group %d", addrs[i], buf[1], i+1)
+			}
+		}
+	}
+}
+
+func TestRelayUDP(t *testing.T) {
+	payload := tsPayload(188)
+	startSender(t, testGroupAddr, payload)
+	_, server := newTestRelay(t, time.Second, false)
+
+	// 不去掉RTP头时原样输出
+	resp, _ := openStream(t, server, testGroupAddr)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	want := rtpPacket(payload, 1, -1, 0)
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("got %x, want %x", buf[:16], want[:16])
+	}
+}
+
+func TestRelayErrors(t *testing.T) {
+	_, server := newTestRelay(t, 100*time.Millisecond, true)
+
+	tests := []struct {
+		name string
+		addr string
+		want int
+	}{
+		{name: "invalid address", addr: "239.255.42.2", want: http.StatusBadRequest},
+		{name: "unicast address", addr: "192.168.1.1:1234", want: http.StatusBadRequest},
+		{name: "no data", addr: "239.255.42.3:51234", want: http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := openStream(t, server, tt.addr)
+			if resp.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package relay
+
+import "encoding/binary"
+
+const (
+	rtpHeaderSize = 12
+	tsSyncByte    = 0x47
+)
+
+// stripRTPHeader 去掉RTP头，返回其中的MPEG-TS负载；若数据包不是RTP格式则原样返回
+func stripRTPHeader(pkt []byte) []byte {
+	// 裸的MPEG-TS数据，无需处理
+	if len(pkt) < rtpHeaderSize || pkt[0] == tsSyncByte {
+		return pkt
+	}
+	// RTP版本号必须为2
+	if pkt[0]>>6 != 2 {
+		return pkt
+	}
+
+	// 跳过固定头和CSRC列表
+	offset := rtpHeaderSize + 4*int(pkt[0]&0x0f)
+	// 跳过扩展头
+	if pkt[0]&0x10 != 0 {
+		if len(pkt) < offset+4 {
+			return nil
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(pkt[offset+2:offset+4]))
+	}
+	// 去掉填充字节
+	end := len(pkt)
+	if pkt[0]&0x20 != 0 && end > 0 {
+		end -= int(pkt[end-1])
+	}
+
+	if offset >= end {
+		return nil
+	}
+	return pkt[offset:end]
+}
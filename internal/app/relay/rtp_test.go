@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+)
+
+// tsPayload 生成以同步字节开头的MPEG-TS负载
+func tsPayload(n int) []byte {
+	payload := bytes.Repeat([]byte{0xff}, n)
+	payload[0] = tsSyncByte
+	return payload
+}
+
+// rtpPacket 生成RTP数据包，csrc为CSRC的数量，ext为扩展头的长度（单位为4字节，为负数时无扩展头），padding为填充字节数
+func rtpPacket(payload []byte, csrc, ext, padding int) []byte {
+	pkt := make([]byte, rtpHeaderSize, rtpHeaderSize+4*csrc+len(payload)+padding)
+	pkt[0] = 2<<6 | byte(csrc)
+	pkt[1] = 33 // MP2T
+	pkt = append(pkt, make([]byte, 4*csrc)...)
+	if ext >= 0 {
+		pkt[0] |= 0x10
+		pkt = append(pkt, 0xbe, 0xde, byte(ext>>8), byte(ext))
+		pkt = append(pkt, make([]byte, 4*ext)...)
+	}
+	pkt = append(pkt, payload...)
+	if padding > 0 {
+		pkt[0] |= 0x20
+		pkt = append(pkt, make([]byte, padding-1)...)
+		pkt = append(pkt, byte(padding))
+	}
+	return pkt
+}
+
+func TestStripRTPHeader(t *testing.T) {
+	payload := tsPayload(188)
+
+	tests := []struct {
+		name string
+		pkt  []byte
+		want []byte
+	}{
+		{name: "plain", pkt: rtpPacket(payload, 0, -1, 0), want: payload},
+		{name: "csrc", pkt: rtpPacket(payload, 3, -1, 0), want: payload},
+		{name: "extension", pkt: rtpPacket(payload, 0, 2, 0), want: payload},
+		{name: "empty extension", pkt: rtpPacket(payload, 0, 0, 0), want: payload},
+		{name: "csrc and extension", pkt: rtpPacket(payload, 2, 1, 0), want: payload},
+		{name: "padding", pkt: rtpPacket(payload, 0, -1, 4), want: payload},
+		{name: "all", pkt: rtpPacket(payload, 15, 3, 7), want: payload},
+		// 裸的MPEG-TS数据原样返回
+		{name: "raw ts", pkt: payload, want: payload},
+		// 非RTP格式的数据原样返回
+		{name: "not rtp", pkt: bytes.Repeat([]byte{0x10}, 20), want: bytes.Repeat([]byte{0x10}, 20)},
+		{name: "short packet", pkt: []byte{0x80, 33, 0, 1}, want: []byte{0x80, 33, 0, 1}},
+		{name: "header only", pkt: rtpPacket(nil, 0, -1, 0), want: nil},
+		// 长度不足以包含CSRC列表或扩展头
+		{name: "truncated csrc", pkt: rtpPacket(nil, 4, -1, 0)[:rtpHeaderSize+4], want: nil},
+		{name: "truncated extension", pkt: rtpPacket(nil, 0, 0, 0)[:rtpHeaderSize+2], want: nil},
+		{name: "truncated extension data", pkt: rtpPacket(nil, 0, 8, 0)[:rtpHeaderSize+8], want: nil},
+		// 填充长度超出数据包长度
+		{name: "invalid padding", pkt: append(rtpPacket(tsPayload(4), 0, -1, 0), 0xff), want: nil},
+	}
+	tests[len(tests)-1].pkt[0] |= 0x20
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripRTPHeader(tt.pkt); !bytes.Equal(got, tt.want) {
+				t.Errorf("stripRTPHeader() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"iptv/internal/app/iptv"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	channelsCacheFileName = "channels.json"
+	epgCacheFileName      = "epg.json"
+)
+
+// 缓存数据的存放目录
+var dataDir string
+
+// cacheFile 缓存文件的内容
+type cacheFile[T any] struct {
+	UpdatedAt time.Time `json:"updatedAt"` // 数据的更新时间
+	Data      T         `json:"data"`      // 缓存的数据
+}
+
+// loadCachedData 从磁盘加载上一次成功更新的频道列表和节目单，返回是否加载到了频道列表
+func loadCachedData() bool {
+	if dataDir == "" {
+		return false
+	}
+
+	var channelsCache cacheFile[[]iptv.Channel]
+	if err := readCacheFile(channelsCacheFileName, &channelsCache); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to load the cached channel list.", zap.Error(err))
+		}
+		return false
+	} else if len(channelsCache.Data) == 0 {
+		return false
+	}
+	storeChannels(channelsCache.Data, channelsCache.UpdatedAt)
+	logger.Sugar().Infof("The cached channel list has been loaded, rows: %d, updated at: %s.",
+		len(channelsCache.Data), channelsCache.UpdatedAt.Format(time.DateTime))
+
+	var epgCache cacheFile[[]iptv.ChannelProgramList]
+	if err := readCacheFile(epgCacheFileName, &epgCache); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to load the cached EPG.", zap.Error(err))
+		}
+	} else {
+		storeEPG(epgCache.Data, epgCache.UpdatedAt)
+		logger.Sugar().Infof("The cached EPG has been loaded, total: %d, updated at: %s.",
+			len(epgCache.Data), epgCache.UpdatedAt.Format(time.DateTime))
+	}
+
+	return true
+}
+
+// saveCachedData 将最新的数据写入缓存文件
+func saveCachedData[T any](fileName string, data T) {
+	if dataDir == "" {
+		return
+	}
+
+	if err := writeCacheFile(fileName, &cacheFile[T]{
+		UpdatedAt: time.Now(),
+		Data:      data,
+	}); err != nil {
+		logger.Warn("Failed to write the cache file.", zap.String("fileName", fileName), zap.Error(err))
+	}
+}
+
+// readCacheFile 读取缓存文件
+func readCacheFile(fileName string, v any) error {
+	content, err := os.ReadFile(filepath.Join(dataDir, fileName))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// writeCacheFile 原子性地写入缓存文件：先写入临时文件，再重命名覆盖
+func writeCacheFile(fileName string, v any) error {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(dataDir, fileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+	// 若未成功重命名，则清理临时文件
+	defer os.Remove(tmpName)
+
+	if err = json.NewEncoder(tmpFile).Encode(v); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, filepath.Join(dataDir, fileName))
+}
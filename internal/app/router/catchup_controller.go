@@ -0,0 +1,227 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"iptv/internal/app/config"
+	"iptv/internal/app/iptv"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var (
+	// 回看地址的生成方式
+	catchupMode string
+	// 请求运营商回看地址时的自定义HTTP请求头
+	catchupHeaders map[string]string
+	// 提供运营商会话Cookie的IPTV客户端，可能为nil
+	sessionProvider iptv.SessionProvider
+	// 代理回看请求的HTTP客户端，回看流持续时间较长因此不设置超时
+	catchupHTTPClient = &http.Client{}
+)
+
+// 需要透传给播放器的响应头
+var catchupProxyHeaders = []string{
+	"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "Cache-Control",
+}
+
+// GetCatchupStream 根据回看的起止时间生成运营商的回看地址，并进行代理或重定向
+func GetCatchupStream(c *gin.Context) {
+	channelID := c.Param("channelID")
+
+	// 查找频道
+	var channel *iptv.Channel
+	channels := loadChannels()
+	for i := range channels {
+		if channels[i].ChannelID == channelID {
+			channel = &channels[i]
+			break
+		}
+	}
+	if channel == nil || channel.TimeShiftURL == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// 获取catchup-source格式
+	_, catchupSource := getCatchupSource(c.Query("csFormat"))
+	if catchupSource == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	catchupSource = strings.TrimLeft(catchupSource, "?&")
+
+	// 解析回看的起止时间
+	begin, end, err := iptv.ParseCatchupTimeRange(c.Request.URL.Query(), catchupSource)
+	if err != nil {
+		logger.Warn("Failed to parse the catchup time range.", zap.String("query", c.Request.URL.RawQuery), zap.Error(err))
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	// 生成运营商的回看地址
+	upstreamURL := *channel.TimeShiftURL
+	if upstreamURL.RawQuery != "" {
+		upstreamURL.RawQuery += "&" + iptv.RenderCatchupSource(catchupSource, begin, end)
+	} else {
+		upstreamURL.RawQuery = iptv.RenderCatchupSource(catchupSource, begin, end)
+	}
+
+	// 非HTTP协议的回看地址（如rtsp）无法代理，只能重定向
+	if catchupMode == config.CatchupModeRedirect ||
+		(upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https") {
+		c.Redirect(http.StatusFound, upstreamURL.String())
+		return
+	}
+
+	if err = proxyCatchupStream(c, channelID, &upstreamURL); err != nil {
+		logger.Error("Failed to proxy the catchup stream.", zap.String("channelID", channelID), zap.Error(err))
+		if !c.Writer.Written() {
+			c.Status(http.StatusBadGateway)
+		}
+	}
+}
+
+// GetCatchupResource 代理HLS播放列表中的分片、密钥及子播放列表，仅接受本服务改写播放列表时签名过的地址
+func GetCatchupResource(c *gin.Context) {
+	channelID := c.Param("channelID")
+	rawURL := c.Query("u")
+	if !hmac.Equal([]byte(c.Query("s")), []byte(signCatchupURL(rawURL))) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	upstreamURL, err := url.Parse(rawURL)
+	if err != nil || (upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https") {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if err = proxyCatchupStream(c, channelID, upstreamURL); err != nil {
+		logger.Error("Failed to proxy the catchup resource.", zap.String("channelID", channelID), zap.Error(err))
+		if !c.Writer.Written() {
+			c.Status(http.StatusBadGateway)
+		}
+	}
+}
+
+// proxyCatchupStream 代理运营商的回看请求
+func proxyCatchupStream(c *gin.Context, channelID string, upstreamURL *url.URL) error {
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, upstreamURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	// 设置请求头
+	for k, v := range catchupHeaders {
+		req.Header.Set(k, v)
+	}
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	// 设置运营商的会话Cookie
+	if sessionProvider != nil {
+		cookies, err := sessionProvider.SessionCookies(c.Request.Context())
+		if err != nil {
+			return err
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	resp, err := catchupHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// HLS播放列表中的地址需要改写为本服务的代理地址，播放器才能携带会话Cookie访问分片
+	if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "mpegurl") ||
+		strings.HasSuffix(strings.ToLower(resp.Request.URL.Path), ".m3u8") {
+		playlist, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), rewriteHLSPlaylist(playlist, resp.Request.URL, channelID))
+		return nil
+	}
+
+	for _, header := range catchupProxyHeaders {
+		if v := resp.Header.Get(header); v != "" {
+			c.Header(header, v)
+		}
+	}
+	c.Status(resp.StatusCode)
+	// 客户端断开连接导致的错误无需处理
+	if _, err = io.Copy(c.Writer, resp.Body); err != nil && c.Request.Context().Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// hlsURIAttrRegex HLS标签中的URI属性，如：#EXT-X-KEY、#EXT-X-MAP、#EXT-X-MEDIA
+var hlsURIAttrRegex = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewriteHLSPlaylist 将HLS播放列表中分片、子播放列表及标签中的URI改写为本服务的代理地址
+func rewriteHLSPlaylist(playlist []byte, baseURL *url.URL, channelID string) []byte {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			line = hlsURIAttrRegex.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := hlsURIAttrRegex.FindStringSubmatch(attr)[1]
+				return `URI="` + proxyCatchupURL(uri, baseURL, channelID) + `"`
+			})
+		default:
+			line = proxyCatchupURL(line, baseURL, channelID)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// proxyCatchupURL 将播放列表中的地址转换为运营商的绝对地址，并生成签名后的代理地址
+func proxyCatchupURL(uri string, baseURL *url.URL, channelID string) string {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	upstreamURL := baseURL.ResolveReference(ref)
+	// data等非HTTP协议的地址无需代理
+	if upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https" {
+		return uri
+	}
+
+	rawURL := upstreamURL.String()
+	return "/catchup/" + url.PathEscape(channelID) + "/proxy?u=" + url.QueryEscape(rawURL) + "&s=" + signCatchupURL(rawURL)
+}
+
+// catchupSignKey 代理地址的签名密钥，每次启动时随机生成，避免代理接口被用于访问任意地址
+var catchupSignKey = func() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}()
+
+// signCatchupURL 计算代理地址的签名
+func signCatchupURL(rawURL string) string {
+	mac := hmac.New(sha256.New, catchupSignKey)
+	mac.Write([]byte(rawURL))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
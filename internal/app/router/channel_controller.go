@@ -7,7 +7,9 @@ import (
 	"iptv/internal/app/iptv"
 	"iptv/internal/pkg/util"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -18,22 +20,33 @@ import (
 var (
 	// 缓存最新的频道列表数据
 	channelsPtr atomic.Pointer[[]iptv.Channel]
+	// 频道列表的更新时间（UnixNano）
+	channelsUpdatedAt atomic.Int64
+	// 自定义的频道列表视图
+	channelViews map[string]*iptv.ChannelView
+	// 频道的合并配置，未启用时为nil
+	channelMerger *iptv.ChannelMerger
+	// 频道的重命名、频道号及排序配置
+	channelCustomizer *iptv.ChannelCustomizer
 )
 
 // GetM3UData 查询直播源m3u
 func GetM3UData(c *gin.Context) {
+	// 校验URL地址的输出方式
+	urlMode := c.Query("urlMode")
+	if !iptv.IsValidURLMode(urlMode) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	// 获取catchup-source格式
-	var catchupSource string
-	csFormat := c.Query("csFormat")
-	if csFormat != "" {
-		// 如果取不到对应的catchup-source，则不生成catchup相关内容
-		catchupSource = catchupSources[csFormat]
-	} else {
-		// 若未指定，则默认随机取其中一个
-		for _, k := range util.SortedMapKeys(catchupSources) {
-			catchupSource = catchupSources[k]
-			break
-		}
+	csFormat, catchupSource := getCatchupSource(c.Query("csFormat"))
+
+	// 通过本服务代理回看请求时，回看地址需携带所使用的catchup-source格式
+	var catchupBaseURL string
+	if catchupMode != "" && catchupSource != "" {
+		catchupBaseURL = fmt.Sprintf("http://%s/catchup", c.Request.Host)
+		catchupSource = "csFormat=" + url.QueryEscape(csFormat) + "&" + strings.TrimLeft(catchupSource, "?&")
 	}
 
 	// 是否优先是由组播地址
@@ -45,19 +58,24 @@ func GetM3UData(c *gin.Context) {
 
 	// 获取指定的udpxy
 	udpxyName := c.Query("udpxy")
-	udpxyURL := getUdpxyURL(udpxyName)
+	udpxyURL := getUdpxyURL(udpxyName, c.Request.Host)
 
-	channels := *channelsPtr.Load()
-	if len(channels) == 0 {
+	channels, ok := loadViewChannels(c.Query("view"))
+	if !ok || len(channels) == 0 {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
+	// 在频道名称后追加当前正在播放的节目名称
+	if isNowTitleEnabled(c) {
+		channels = appendNowTitles(channels, time.Now())
+	}
+
 	// 设置台标的统一Base URL
 	logoBaseUrl := fmt.Sprintf("http://%s/logo", c.Request.Host)
 
 	// 将获取到的频道列表转换为m3u格式
-	m3uContent, err := iptv.ToM3UFormat(channels, udpxyURL, catchupSource, multicastFirst, logoBaseUrl)
+	m3uContent, err := iptv.ToM3UFormat(channels, udpxyURL, catchupSource, multicastFirst, urlMode, logoBaseUrl, catchupBaseURL)
 	if err != nil {
 		logger.Error("Failed to convert channel list to m3u format.", zap.Error(err))
 		// 返回响应
@@ -69,8 +87,21 @@ func GetM3UData(c *gin.Context) {
 	c.String(http.StatusOK, m3uContent)
 }
 
+// isNowTitleEnabled 是否在频道名称后追加当前正在播放的节目名称
+func isNowTitleEnabled(c *gin.Context) bool {
+	nowTitle, err := strconv.ParseBool(c.DefaultQuery("nowTitle", "false"))
+	return err == nil && nowTitle
+}
+
 // GetTXTData 查询直播源txt
 func GetTXTData(c *gin.Context) {
+	// 校验URL地址的输出方式
+	urlMode := c.Query("urlMode")
+	if !iptv.IsValidURLMode(urlMode) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	// 是否优先是由组播地址
 	multiFirstStr := c.DefaultQuery("multiFirst", "true")
 	multicastFirst, err := strconv.ParseBool(multiFirstStr)
@@ -80,16 +111,16 @@ func GetTXTData(c *gin.Context) {
 
 	// 获取指定的udpxy
 	udpxyName := c.Query("udpxy")
-	udpxyURL := getUdpxyURL(udpxyName)
+	udpxyURL := getUdpxyURL(udpxyName, c.Request.Host)
 
-	channels := *channelsPtr.Load()
-	if len(channels) == 0 {
+	channels, ok := loadViewChannels(c.Query("view"))
+	if !ok || len(channels) == 0 {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
 	// 将获取到的频道列表转换为txt格式
-	txtContent, err := iptv.ToTxtFormat(channels, udpxyURL, multicastFirst)
+	txtContent, err := iptv.ToTxtFormat(channels, udpxyURL, multicastFirst, urlMode)
 	if err != nil {
 		logger.Error("Failed to convert channel list to txt format.", zap.Error(err))
 		// 返回响应
@@ -103,6 +134,13 @@ func GetTXTData(c *gin.Context) {
 
 // GetPLSData 查询直播源pls
 func GetPLSData(c *gin.Context) {
+	// 校验URL地址的输出方式
+	urlMode := c.Query("urlMode")
+	if !iptv.IsValidURLMode(urlMode) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
 	// 是否优先是由组播地址
 	multiFirstStr := c.DefaultQuery("multiFirst", "true")
 	multicastFirst, err := strconv.ParseBool(multiFirstStr)
@@ -112,16 +150,16 @@ func GetPLSData(c *gin.Context) {
 
 	// 获取指定的udpxy
 	udpxyName := c.Query("udpxy")
-	udpxyURL := getUdpxyURL(udpxyName)
+	udpxyURL := getUdpxyURL(udpxyName, c.Request.Host)
 
-	channels := *channelsPtr.Load()
-	if len(channels) == 0 {
+	channels, ok := loadViewChannels(c.Query("view"))
+	if !ok || len(channels) == 0 {
 		c.Status(http.StatusNotFound)
 		return
 	}
 
 	// 将获取到的频道列表转换为pls格式
-	content, err := iptv.ToPLSFormat(channels, udpxyURL, multicastFirst)
+	content, err := iptv.ToPLSFormat(channels, udpxyURL, multicastFirst, urlMode)
 	if err != nil {
 		logger.Error("Failed to convert channel list to pls format.", zap.Error(err))
 		// 返回响应
@@ -133,8 +171,29 @@ func GetPLSData(c *gin.Context) {
 	c.String(http.StatusOK, content)
 }
 
+// getCatchupSource 通过名称获取catchup-source格式，返回实际使用的名称和格式
+func getCatchupSource(csFormat string) (string, string) {
+	catchupSources := loadReloadable().catchupSources
+	if csFormat != "" {
+		// 如果取不到对应的catchup-source，则不生成catchup相关内容
+		return csFormat, catchupSources[csFormat]
+	}
+
+	// 若未指定，则默认随机取其中一个
+	for _, k := range util.SortedMapKeys(catchupSources) {
+		return k, catchupSources[k]
+	}
+	return "", ""
+}
+
 // getUdpxyURL 通过udpxy的名称来获取指定的URL地址
-func getUdpxyURL(udpxyName string) string {
+// 若未配置任何udpxy且启用了内置的组播转单播服务，则使用当前服务的地址
+func getUdpxyURL(udpxyName, host string) string {
+	udpxyURLs := loadReloadable().udpxyURLs
+	if udpxyName == "" && len(udpxyURLs) == 0 && multicastRelay != nil {
+		return fmt.Sprintf("http://%s", host)
+	}
+
 	var udpxyURL string
 	if udpxyName != "" {
 		// 获取指定名称的udpxy的URL
@@ -149,22 +208,19 @@ func getUdpxyURL(udpxyName string) string {
 	return udpxyURL
 }
 
-// updateChannelsWithRetry 更新缓存的频道数据（失败重试）
-func updateChannelsWithRetry(ctx context.Context, iptvClient iptv.Client, maxRetries int) error {
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		if err = updateChannels(ctx, iptvClient); err != nil {
-			logger.Sugar().Errorf("Failed to update channel list, will try again after waiting %d seconds. Error: %v, number of retries: %d.", waitSeconds, err, i)
-			time.Sleep(waitSeconds * time.Second)
-		} else {
-			break
-		}
-	}
-	return err
+// updateChannelsWithRetry 更新缓存的频道数据（失败时按指数退避重试）
+func updateChannelsWithRetry(ctx context.Context, iptvClient iptv.Client) error {
+	return retryWithBackoff(ctx, "channel list", func() error {
+		return updateChannels(ctx, iptvClient)
+	})
 }
 
 // updateChannels 更新缓存的频道数据
-func updateChannels(ctx context.Context, iptvClient iptv.Client) error {
+func updateChannels(ctx context.Context, iptvClient iptv.Client) (err error) {
+	defer func() {
+		channelsRefresh.record(err)
+	}()
+
 	// 查询最新的频道列表
 	channels, err := iptvClient.GetAllChannelList(ctx)
 	if err != nil {
@@ -176,8 +232,58 @@ func updateChannels(ctx context.Context, iptvClient iptv.Client) error {
 	}
 
 	logger.Sugar().Infof("The channel list has been updated, rows: %d.", len(channels))
-	// 更新缓存的频道列表
-	channelsPtr.Store(&channels)
+	// 更新缓存的频道列表，磁盘中保存未经自定义处理的原始数据
+	storeChannels(channels, time.Now())
+	saveCachedData(channelsCacheFileName, channels)
+
+	return nil
+}
+
+// storeChannels 使用当前的规则过滤频道并重新识别频道的分组及台标，再进行合并及自定义处理（重命名、频道号及排序）后更新缓存。
+// 缓存未经过滤的原始频道列表，热加载修改过滤规则后可据此重新过滤
+func storeChannels(channels []iptv.Channel, updatedAt time.Time) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	storeChannelsLocked(channels, updatedAt)
+}
 
+// storeChannelsLocked 同storeChannels，调用方需持有channelsMu
+func storeChannelsLocked(channels []iptv.Channel, updatedAt time.Time) {
+	rawChannelsPtr.Store(&channels)
+
+	filteredChannels := loadReloadable().channelRules.Apply(channels)
+	customChannels := channelCustomizer.Apply(channelMerger.Merge(filteredChannels))
+	channelsPtr.Store(&customChannels)
+	channelsUpdatedAt.Store(updatedAt.UnixNano())
+}
+
+// loadViewChannels 获取指定视图中的频道列表，视图名称为空时返回全部频道，视图不存在时返回false
+func loadViewChannels(viewName string) ([]iptv.Channel, bool) {
+	channels := loadChannels()
+	if viewName == "" {
+		return channels, true
+	}
+
+	view, ok := channelViews[viewName]
+	if !ok {
+		logger.Warn("The channel view does not exist.", zap.String("view", viewName))
+		return nil, false
+	}
+	return view.Filter(channels), true
+}
+
+// loadChannels 获取缓存的频道列表，尚未获取到数据时返回nil
+func loadChannels() []iptv.Channel {
+	if channels := channelsPtr.Load(); channels != nil {
+		return *channels
+	}
+	return nil
+}
+
+// loadRawChannels 获取缓存的未经过滤及合并的原始频道列表，尚未获取到数据时返回nil
+func loadRawChannels() []iptv.Channel {
+	if channels := rawChannelsPtr.Load(); channels != nil {
+		return *channels
+	}
 	return nil
 }
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"iptv/internal/app/iptv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 归一化频道名称时去掉的字符
+var channelNameReplacer = strings.NewReplacer(" ", "", "-", "", "_", "", "·", "", "＋", "+")
+
+// 前缀匹配时前缀的最小字符数，避免过短的名称（如c、湖南）匹配到其他频道
+const minPrefixMatchRunes = 3
+
+// findChannel 根据频道ID、频道号、频道名称或归一化后的频道名称查询频道，查询不到时返回nil
+func findChannel(channels []iptv.Channel, query string) *iptv.Channel {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+
+	// 依次按频道ID、频道号和频道名称精确匹配
+	for _, match := range []func(channel *iptv.Channel) bool{
+		func(channel *iptv.Channel) bool { return channel.ChannelID == query },
+		func(channel *iptv.Channel) bool { return channel.UserChannelID == query },
+		func(channel *iptv.Channel) bool { return channel.ChannelName == query },
+	} {
+		for i := range channels {
+			if match(&channels[i]) {
+				return &channels[i]
+			}
+		}
+	}
+
+	// 按归一化后的名称匹配，如：cctv1综合、CCTV-1均可匹配CCTV-1高清
+	logoRules := loadReloadable().channelRules.LogoRuleList
+	queryKey := normalizeChannelName(iptv.GetChannelLogoName(logoRules, query))
+	if queryKey == "" {
+		return nil
+	}
+	var matched *iptv.Channel
+	var matchedLen int
+	for i := range channels {
+		for _, key := range channelNameKeys(&channels[i], logoRules) {
+			if key == queryKey {
+				return &channels[i]
+			}
+			// 前缀匹配时取最长的匹配结果
+			if n := prefixMatchLen(queryKey, key); n > matchedLen {
+				matched, matchedLen = &channels[i], n
+			}
+		}
+	}
+	return matched
+}
+
+// channelNameKeys 获取频道归一化后的名称，包括台标名称和（重命名后的）频道名称
+func channelNameKeys(channel *iptv.Channel, logoRules []iptv.ChannelLogoRule) []string {
+	keys := make([]string, 0, 2)
+	if channel.LogoName != "" {
+		keys = append(keys, normalizeChannelName(channel.LogoName))
+	}
+	keys = append(keys, normalizeChannelName(iptv.GetChannelLogoName(logoRules, channel.ChannelName)))
+	return keys
+}
+
+// normalizeChannelName 将频道名称转为小写，并去掉空格、连接符等字符
+func normalizeChannelName(name string) string {
+	return strings.ToLower(channelNameReplacer.Replace(name))
+}
+
+// prefixMatchLen 其中一个名称是另一个的前缀时返回前缀的长度，否则返回0。
+// 前缀需至少包含minPrefixMatchRunes个字符，且前缀之后需是新的词：不是数字（避免CCTV1匹配到CCTV10），
+// 前缀以字母结尾时也不是字母（避免cctv匹配到cctvx）
+func prefixMatchLen(a, b string) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if utf8.RuneCountInString(b) < minPrefixMatchRunes || !strings.HasPrefix(a, b) {
+		return 0
+	}
+	next, _ := utf8.DecodeRuneInString(a[len(b):])
+	last, _ := utf8.DecodeLastRuneInString(b)
+	if unicode.IsDigit(next) || (isASCIILetter(last) && isASCIILetter(next)) {
+		return 0
+	}
+	return len(b)
+}
+
+func isASCIILetter(r rune) bool {
+	return r < utf8.RuneSelf && unicode.IsLetter(r)
+}
@@ -1,14 +1,14 @@
 package router
 
 import (
-	"compress/gzip"
 	"context"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"iptv/internal/app/iptv"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -26,6 +26,20 @@ const (
 var (
 	// 缓存最新的节目单数据
 	epgPtr atomic.Pointer[[]iptv.ChannelProgramList]
+	// 节目单的更新时间（UnixNano）
+	epgUpdatedAt atomic.Int64
+
+	// 节目单时间所在的时区
+	epgLocation = time.Local
+	// xmltv中的语言代码
+	epgLang = "zh"
+)
+
+const (
+	// 没有节目单时使用的占位节目名称
+	placeholderProgramName = "精彩节目"
+	// 查询节目单的最大日期范围
+	maxJsonEPGDays = 31
 )
 
 // ChannelDateJsonEPG 频道的JSON格式EPG
@@ -37,21 +51,29 @@ type ChannelDateJsonEPG struct {
 
 // JsonEPG JSON格式EPG
 type JsonEPG struct {
-	Title string `json:"title"` // 标题
-	Desc  string `json:"desc"`  // 描述
-	Start string `json:"start"` // 开始时间
-	End   string `json:"end"`   // 结束时间
+	Title string `json:"title"`          // 标题
+	Desc  string `json:"desc"`           // 描述
+	Start string `json:"start"`          // 开始时间
+	End   string `json:"end"`            // 结束时间
+	Date  string `json:"date,omitempty"` // 节目日期，仅查询多个日期时返回
 }
 
 // GetJsonEPG 获取JSON格式的EPG
+// ch支持频道ID、频道号、频道名称或近似的频道名称，多个频道使用逗号分隔；
+// date支持单个日期、日期范围（如2024-11-20~2024-11-22）或now（当前及下一个节目）
 func GetJsonEPG(c *gin.Context) {
 	// 获取频道名称
-	chName := c.Query("ch")
+	chNames := make([]string, 0)
+	for _, chName := range strings.Split(c.Query("ch"), ",") {
+		if chName = strings.TrimSpace(chName); chName != "" {
+			chNames = append(chNames, chName)
+		}
+	}
 	// 获取日期
 	dateStr := c.DefaultQuery("date", time.Now().Format("2006-01-02"))
 
 	// 校验频道名称是否为空
-	if chName == "" {
+	if len(chNames) == 0 {
 		logger.Warn("The name of the channel is null.")
 		// 返回响应
 		c.Status(http.StatusBadRequest)
@@ -59,88 +81,264 @@ func GetJsonEPG(c *gin.Context) {
 	}
 
 	// 解析日期
-	date, err := time.ParseInLocation("2006-01-02", dateStr, time.Local)
+	dates, err := parseJsonEPGDates(dateStr)
 	if err != nil {
 		logger.Error("Date format error", zap.Error(err))
 		c.Status(http.StatusBadRequest)
 		return
 	}
 
-	// 空响应
-	emptyResp := ChannelDateJsonEPG{
-		ChannelName: chName,
-		Date:        dateStr,
-		EPGData:     []JsonEPG{},
+	channels := loadChannels()
+	chProgLists := loadEPG()
+	results := make([]ChannelDateJsonEPG, 0, len(chNames))
+	for _, chName := range chNames {
+		// 根据频道名称查询到该频道所有日期的节目单列表
+		chProgList := findChannelProgramList(channels, chProgLists, chName)
+
+		result := ChannelDateJsonEPG{
+			ChannelName: chName,
+			Date:        dateStr,
+		}
+		if dates == nil {
+			// 查询当前及下一个节目
+			now := time.Now()
+			result.Date = now.Format("2006-01-02")
+			result.EPGData = getNowNextJsonEPG(chProgList, now)
+		} else {
+			result.EPGData = getDatesJsonEPG(chProgList, dates)
+		}
+		results = append(results, result)
 	}
 
-	// 如果缓存的节目单列表为空则直接返回空数据
-	chProgLists := *epgPtr.Load()
-	if len(chProgLists) == 0 {
-		c.PureJSON(http.StatusOK, &emptyResp)
-		return
+	// 返回最终响应，查询多个频道时返回数组
+	if len(results) == 1 {
+		c.PureJSON(http.StatusOK, &results[0])
+	} else {
+		c.PureJSON(http.StatusOK, results)
 	}
+}
 
-	// 根据频道名称查询到该频道所有日期的节目单列表
-	var tagerChProgList *iptv.ChannelProgramList
-	for _, chProgList := range chProgLists {
-		if chProgList.ChannelName == chName {
-			tagerChProgList = &chProgList
-			break
+// parseJsonEPGDates 解析查询的日期，支持yyyy-MM-dd和yyyyMMdd格式，以及使用~分隔的日期范围；查询当前节目（now）时返回nil
+func parseJsonEPGDates(dateStr string) ([]time.Time, error) {
+	if strings.EqualFold(dateStr, "now") {
+		return nil, nil
+	}
+
+	beginStr, endStr, isRange := strings.Cut(dateStr, "~")
+	begin, err := parseJsonEPGDate(beginStr)
+	if err != nil {
+		return nil, err
+	}
+	end := begin
+	if isRange {
+		if end, err = parseJsonEPGDate(endStr); err != nil {
+			return nil, err
 		}
 	}
-	if tagerChProgList == nil || len(tagerChProgList.DateProgramList) == 0 {
-		c.PureJSON(http.StatusOK, &emptyResp)
-		return
+	if end.Before(begin) || end.Sub(begin) >= maxJsonEPGDays*24*time.Hour {
+		return nil, fmt.Errorf("invalid date range: %s", dateStr)
 	}
 
-	// 查询该频道指定日期的节目单列表
-	dateEPGData := make([]JsonEPG, 0)
-	for _, dateProgList := range tagerChProgList.DateProgramList {
-		if dateProgList.Date.Equal(date) {
-			if len(dateProgList.ProgramList) > 0 {
-				for _, program := range dateProgList.ProgramList {
-					dateEPGData = append(dateEPGData, JsonEPG{
-						Title: program.ProgramName,
-						Start: program.StartTime,
-						End:   program.EndTime,
-					})
+	dates := make([]time.Time, 0)
+	for date := begin; !date.After(end); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date)
+	}
+	return dates, nil
+}
+
+func parseJsonEPGDate(dateStr string) (time.Time, error) {
+	dateStr = strings.TrimSpace(dateStr)
+	if date, err := time.ParseInLocation("2006-01-02", dateStr, time.Local); err == nil {
+		return date, nil
+	}
+	return time.ParseInLocation("20060102", dateStr, time.Local)
+}
+
+// findChannelProgramList 根据频道ID、频道号或名称查询频道的节目单列表，查询不到时返回nil
+func findChannelProgramList(channels []iptv.Channel, chProgLists []iptv.ChannelProgramList, chName string) *iptv.ChannelProgramList {
+	if channel := findChannel(channels, chName); channel != nil {
+		for i := range chProgLists {
+			if chProgLists[i].ChannelId == channel.ChannelID {
+				return &chProgLists[i]
+			}
+		}
+	}
+	// 频道列表中已不存在的频道，按节目单中的频道名称匹配
+	for i := range chProgLists {
+		if chProgLists[i].ChannelName == chName {
+			return &chProgLists[i]
+		}
+	}
+	return nil
+}
+
+// getDatesJsonEPG 查询频道多个日期的节目单，没有节目单的日期使用占位节目
+func getDatesJsonEPG(chProgList *iptv.ChannelProgramList, dates []time.Time) []JsonEPG {
+	epgData := make([]JsonEPG, 0)
+	for _, date := range dates {
+		dateEPGData := make([]JsonEPG, 0)
+		for _, program := range getDatePrograms(chProgList, date) {
+			dateEPGData = append(dateEPGData, JsonEPG{
+				Title: program.ProgramName,
+				Desc:  program.Desc,
+				Start: program.StartTime,
+				End:   program.EndTime,
+			})
+		}
+		if len(dateEPGData) == 0 {
+			dateEPGData = placeholderJsonEPG(0, 24)
+		}
+
+		// 查询多个日期时，标明节目所属的日期
+		if len(dates) > 1 {
+			for i := range dateEPGData {
+				dateEPGData[i].Date = date.Format("2006-01-02")
+			}
+		}
+		epgData = append(epgData, dateEPGData...)
+	}
+	return epgData
+}
+
+// getNowNextJsonEPG 查询频道当前及下一个节目，没有节目单时使用占位节目
+func getNowNextJsonEPG(chProgList *iptv.ChannelProgramList, now time.Time) []JsonEPG {
+	if chProgList != nil {
+		if current, next := chProgList.NowNext(now); current != nil {
+			epgData := make([]JsonEPG, 0, 2)
+			for _, p := range []*iptv.Program{current, next} {
+				if p == nil {
+					continue
 				}
+				epgData = append(epgData, JsonEPG{
+					Title: p.ProgramName,
+					Desc:  p.Desc,
+					Start: p.StartTime,
+					End:   p.EndTime,
+				})
 			}
-			break
+			return epgData
 		}
 	}
+	return placeholderJsonEPG(now.Hour(), min(now.Hour()+2, 24))
+}
 
-	// 返回最终响应
-	c.PureJSON(http.StatusOK, &ChannelDateJsonEPG{
-		ChannelName: chName,
-		Date:        dateStr,
-		EPGData:     dateEPGData,
-	})
+// ChannelNowNextEPG 频道当前及下一个节目
+type ChannelNowNextEPG struct {
+	ChannelID     string        `json:"channelID"`     // 频道ID
+	ChannelName   string        `json:"channelName"`   // 频道名称
+	UserChannelID string        `json:"userChannelID"` // 频道号
+	Now           *iptv.Program `json:"now"`           // 当前节目
+	Next          *iptv.Program `json:"next"`          // 下一个节目
+}
+
+// GetNowNextEPG 查询频道当前及下一个节目，可通过ch（多个使用逗号分隔）或view筛选频道
+func GetNowNextEPG(c *gin.Context) {
+	channels, ok := loadViewChannels(c.Query("view"))
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// 按频道ID、频道号或名称筛选频道
+	if chParam := c.Query("ch"); chParam != "" {
+		filtered := make([]iptv.Channel, 0)
+		for _, chName := range strings.Split(chParam, ",") {
+			if channel := findChannel(channels, chName); channel != nil {
+				filtered = append(filtered, *channel)
+			}
+		}
+		channels = filtered
+	}
+
+	chProgListMap := make(map[string]*iptv.ChannelProgramList)
+	chProgLists := loadEPG()
+	for i := range chProgLists {
+		chProgListMap[chProgLists[i].ChannelId] = &chProgLists[i]
+	}
+
+	now := time.Now()
+	result := make([]ChannelNowNextEPG, 0, len(channels))
+	for _, channel := range channels {
+		nowNext := ChannelNowNextEPG{
+			ChannelID:     channel.ChannelID,
+			ChannelName:   channel.ChannelName,
+			UserChannelID: channel.UserChannelID,
+		}
+		if chProgList, ok := chProgListMap[channel.ChannelID]; ok {
+			nowNext.Now, nowNext.Next = chProgList.NowNext(now)
+		}
+		result = append(result, nowNext)
+	}
+	c.PureJSON(http.StatusOK, result)
+}
+
+// appendNowTitles 在频道名称后追加当前正在播放的节目名称，不修改原有的频道列表
+func appendNowTitles(channels []iptv.Channel, now time.Time) []iptv.Channel {
+	chProgListMap := make(map[string]*iptv.ChannelProgramList)
+	chProgLists := loadEPG()
+	for i := range chProgLists {
+		chProgListMap[chProgLists[i].ChannelId] = &chProgLists[i]
+	}
+
+	result := slices.Clone(channels)
+	for i := range result {
+		chProgList, ok := chProgListMap[result[i].ChannelID]
+		if !ok {
+			continue
+		}
+		if current, _ := chProgList.NowNext(now); current != nil {
+			result[i].ChannelName += " - " + current.ProgramName
+		}
+	}
+	return result
 }
 
-// XmlEPG XMLTV格式的EPG
-type XmlEPG struct {
-	XMLName           xml.Name          `xml:"tv"`
-	SourceInfoUrl     string            `xml:"source-info-url,attr,omitempty"`
-	SourceInfoName    string            `xml:"source-info-name,attr,omitempty"`
-	SourceDataUrl     string            `xml:"source-data-url,attr,omitempty"`
-	GeneratorInfoName string            `xml:"generator-info-name,attr,omitempty"`
-	GeneratorInfoUrl  string            `xml:"generator-info-url,attr,omitempty"`
-	Channels          []XmlEPGChannel   `xml:"channel,omitempty"`
-	Programmes        []XmlEPGProgramme `xml:"programme,omitempty"`
+// getDatePrograms 获取频道指定日期的节目列表
+func getDatePrograms(chProgList *iptv.ChannelProgramList, date time.Time) []iptv.Program {
+	if chProgList == nil {
+		return nil
+	}
+	for _, dateProgList := range chProgList.DateProgramList {
+		if dateProgList.Date.Equal(date) {
+			return dateProgList.ProgramList
+		}
+	}
+	return nil
+}
+
+// placeholderJsonEPG 生成指定小时范围内每小时一个的占位节目
+func placeholderJsonEPG(fromHour, toHour int) []JsonEPG {
+	epgData := make([]JsonEPG, 0, toHour-fromHour)
+	for hour := fromHour; hour < toHour; hour++ {
+		end := fmt.Sprintf("%02d:00", hour+1)
+		if hour == 23 {
+			end = "23:59"
+		}
+		epgData = append(epgData, JsonEPG{
+			Title: placeholderProgramName,
+			Start: fmt.Sprintf("%02d:00", hour),
+			End:   end,
+		})
+	}
+	return epgData
 }
 
 type XmlEPGChannel struct {
 	Id          string         `xml:"id,attr"`
 	DisplayName *XmlEPGDisplay `xml:"display-name"`
+	Icon        *XmlEPGIcon    `xml:"icon,omitempty"`
+	LCN         string         `xml:"lcn,omitempty"`
 }
 
 type XmlEPGProgramme struct {
-	Start   string         `xml:"start,attr"`
-	Stop    string         `xml:"stop,attr"`
-	Channel string         `xml:"channel,attr"`
-	Title   *XmlEPGDisplay `xml:"title"`
-	Desc    *XmlEPGDisplay `xml:"desc,omitempty"`
+	Start      string            `xml:"start,attr"`
+	Stop       string            `xml:"stop,attr"`
+	Channel    string            `xml:"channel,attr"`
+	Title      *XmlEPGDisplay    `xml:"title"`
+	Desc       *XmlEPGDisplay    `xml:"desc,omitempty"`
+	Category   *XmlEPGDisplay    `xml:"category,omitempty"`
+	EpisodeNum *XmlEPGEpisodeNum `xml:"episode-num,omitempty"`
+	Rating     *XmlEPGRating     `xml:"rating,omitempty"`
 }
 
 type XmlEPGDisplay struct {
@@ -148,156 +346,205 @@ type XmlEPGDisplay struct {
 	Value string `xml:",chardata"`
 }
 
+type XmlEPGIcon struct {
+	Src string `xml:"src,attr"`
+}
+
+type XmlEPGEpisodeNum struct {
+	System string `xml:"system,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type XmlEPGRating struct {
+	Value string `xml:"value"`
+}
+
 // GetXmlEPG 返回XMLTV格式的EPG
 func GetXmlEPG(c *gin.Context) {
-	var err error
+	backDay := getBackDay(c)
+	logoBaseUrl := fmt.Sprintf("http://%s/logo", c.Request.Host)
 
-	// 保留过去几天的节目单
-	backDay := 0
-	backDayStr := c.Query("backDay")
-	if backDayStr != "" {
-		if backDay, err = strconv.Atoi(backDayStr); err != nil {
-			backDay = 0
-		}
+	// 客户端接受gzip时直接返回缓存的压缩内容，否则逐个编码后直接写入响应
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	var err error
+	if acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Header("Content-Encoding", "gzip")
+		c.Status(http.StatusOK)
+		err = writeGzipXmlEPG(c.Writer, backDay, logoBaseUrl)
+	} else {
+		c.Status(http.StatusOK)
+		err = writeXmlEPG(c.Writer, loadEPG(), backDay, logoBaseUrl)
 	}
-
-	// 如果缓存的节目单列表为空则直接返回空数据
-	chProgLists := *epgPtr.Load()
-	if len(chProgLists) == 0 {
-		c.XML(http.StatusOK, &XmlEPG{
-			GeneratorInfoName: xmltvGenInfoName,
-			GeneratorInfoUrl:  xmltvGenInfoUrl,
-		})
-		return
+	if err != nil {
+		logger.Warn("Failed to write xmltv.", zap.Error(err))
 	}
-
-	xmlEPG := getXmlEPG(chProgLists, backDay)
-
-	c.XML(http.StatusOK, xmlEPG)
 }
 
 func GetXmlEPGWithGzip(c *gin.Context) {
-	var err error
+	backDay := getBackDay(c)
 
-	// 保留过去几天的节目单
-	backDay := 0
-	backDayStr := c.Query("backDay")
-	if backDayStr != "" {
-		if backDay, err = strconv.Atoi(backDayStr); err != nil {
-			backDay = 0
-		}
+	// 设置HTTP头，通知浏览器这是一个gzip压缩文件
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", xmltvGzipFilename)) // 指定下载文件名
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+
+	// 写入缓存的xmltv内容（gzip压缩）
+	if err := writeGzipXmlEPG(c.Writer, backDay, fmt.Sprintf("http://%s/logo", c.Request.Host)); err != nil {
+		logger.Warn("Failed to write xmltv.", zap.Error(err))
 	}
+}
 
-	var xmlEPG *XmlEPG
-	// 如果缓存的节目单列表为空则直接返回空数据
-	chProgLists := *epgPtr.Load()
-	if len(chProgLists) == 0 {
-		xmlEPG = &XmlEPG{
-			GeneratorInfoName: xmltvGenInfoName,
-			GeneratorInfoUrl:  xmltvGenInfoUrl,
-		}
-	} else {
-		xmlEPG = getXmlEPG(chProgLists, backDay)
+// getBackDay 获取保留过去几天的节目单的参数，缺省为全部；超出节目单保留的天数时按保留的天数处理
+func getBackDay(c *gin.Context) int {
+	backDay, err := strconv.Atoi(c.Query("backDay"))
+	if err != nil || backDay < 0 {
+		return 0
+	}
+	return min(backDay, epgRetentionDays)
+}
+
+// getXmlEPGProgramme 将节目转为xmltv格式，可选信息为空时不输出对应的元素
+func getXmlEPGProgramme(channelId string, program *iptv.Program) XmlEPGProgramme {
+	programme := XmlEPGProgramme{
+		Start:   formatXmltvTime(program.BeginTimeFormat),
+		Stop:    formatXmltvTime(program.EndTimeFormat),
+		Channel: channelId,
+		Title: &XmlEPGDisplay{
+			Lang:  epgLang,
+			Value: program.ProgramName,
+		},
 	}
+	if program.Desc != "" {
+		programme.Desc = &XmlEPGDisplay{Lang: epgLang, Value: program.Desc}
+	}
+	if program.Category != "" {
+		programme.Category = &XmlEPGDisplay{Lang: epgLang, Value: program.Category}
+	}
+	if program.EpisodeNum != "" {
+		programme.EpisodeNum = &XmlEPGEpisodeNum{System: "onscreen", Value: program.EpisodeNum}
+	}
+	if program.Rating != "" {
+		programme.Rating = &XmlEPGRating{Value: program.Rating}
+	}
+	return programme
+}
 
-	// 将结构体数据转换为XML，并进行格式化
-	xmlData, err := xml.MarshalIndent(xmlEPG, "", "  ")
+// formatXmltvTime 将节目的时间转换为xmltv的时间格式，时区偏移根据配置的时区计算，例如：20241122205700 +0800
+func formatXmltvTime(timeFormat string) string {
+	t, err := time.ParseInLocation("20060102150405", timeFormat, epgLocation)
 	if err != nil {
-		logger.Error("Failed to marshal xml.", zap.Error(err))
-		c.Status(http.StatusInternalServerError)
-		return
+		return timeFormat
 	}
+	return t.Format("20060102150405 -0700")
+}
 
-	// 设置HTTP头，通知浏览器这是一个二进制流文件
-	c.Header("Transfer-Encoding", "gzip")                                                      // 说明文件是gzip压缩格式
-	c.Header("Content-Type", "application/octet-stream")                                       // 说明是二进制文件
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", xmltvGzipFilename)) // 指定下载文件名
+// updateEPGWithRetry 更新缓存的节目单（失败时按指数退避重试）
+func updateEPGWithRetry(ctx context.Context, iptvClient iptv.Client, todayOnly bool) error {
+	return retryWithBackoff(ctx, "EPG", func() error {
+		return updateEPG(ctx, iptvClient, todayOnly)
+	})
+}
 
-	// 创建一个gzip压缩的Writer，并将XML数据写入其中
-	gzipWriter := gzip.NewWriter(c.Writer)
-	defer gzipWriter.Close()
+// updateEPG 更新缓存的节目单数据，todayOnly为true时只获取当天及未来的节目单（IPTV客户端不支持时仍获取全部日期）
+func updateEPG(ctx context.Context, iptvClient iptv.Client, todayOnly bool) (err error) {
+	defer func() {
+		epgRefresh.record(err)
+	}()
 
-	// 写入xml头
-	if _, err = gzipWriter.Write([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")); err != nil {
-		logger.Error("Failed to write xml header.", zap.Error(err))
-		c.Status(http.StatusInternalServerError)
-		return
+	// 获取缓存的所有频道列表
+	channels := loadChannels()
+	if len(channels) == 0 {
+		return errors.New("no channels")
 	}
 
-	// 写入xml内容
-	if _, err = gzipWriter.Write(xmlData); err != nil {
-		logger.Error("Failed to write xml data.", zap.Error(err))
-		c.Status(http.StatusInternalServerError)
-		return
+	// 获取所有频道的节目单列表，合并掉的其他清晰度版本同样获取，保留的版本没有节目单时使用
+	epgChannels := appendMergedChannels(channels, loadRawChannels())
+	var allChProgramList []iptv.ChannelProgramList
+	if recentProvider, ok := iptvClient.(iptv.RecentProgramListProvider); ok && todayOnly {
+		allChProgramList, err = recentProvider.GetRecentChannelProgramList(ctx, epgChannels, 0)
+	} else {
+		allChProgramList, err = iptvClient.GetAllChannelProgramList(ctx, epgChannels)
 	}
-}
+	if err != nil {
+		return err
+	}
+	allChProgramList = selectMergedProgramLists(channels, allChProgramList)
 
-// getXmlEPG 将频道节目单转为xmltv格式
-func getXmlEPG(chProgLists []iptv.ChannelProgramList, backDay int) *XmlEPG {
-	backTime := time.Now().AddDate(0, 0, -backDay)
-	backTime = time.Date(backTime.Year(), backTime.Month(), backTime.Day(), 0, 0, 0, 0, backTime.Location())
-
-	channels := make([]XmlEPGChannel, 0, len(chProgLists))
-	programmes := make([]XmlEPGProgramme, 0)
-	for _, chProgList := range chProgLists {
-		// 获取频道的相关信息
-		channels = append(channels, XmlEPGChannel{
-			Id: chProgList.ChannelId,
-			DisplayName: &XmlEPGDisplay{
-				Lang:  "zh",
-				Value: chProgList.ChannelName,
-			},
-		})
+	// 将最新的节目单合并到缓存的节目单中，并丢弃超出保留天数的数据
+	now := time.Now()
+	retainFrom := time.Date(now.Year(), now.Month(), now.Day()-epgRetentionDays, 0, 0, 0, 0, now.Location())
+	mergedChProgramList := iptv.MergeChannelProgramLists(loadEPG(), allChProgramList, retainFrom)
 
-		if len(chProgList.DateProgramList) == 0 {
-			continue
-		}
+	logger.Sugar().Infof("EPG data updated, fetched: %d, total: %d.", len(allChProgramList), len(mergedChProgramList))
+	// 更新缓存的节目单
+	storeEPG(mergedChProgramList, now)
+	saveCachedData(epgCacheFileName, mergedChProgramList)
 
-		for _, dateProgList := range chProgList.DateProgramList {
-			if len(dateProgList.ProgramList) == 0 ||
-				(backDay > 0 && !backTime.Before(dateProgList.Date)) {
-				continue
-			}
-			for _, program := range dateProgList.ProgramList {
-				// 获取节目的相关信息
-				programmes = append(programmes, XmlEPGProgramme{
-					Start:   program.BeginTimeFormat + " +0800",
-					Stop:    program.EndTimeFormat + " +0800",
-					Channel: chProgList.ChannelId,
-					Title: &XmlEPGDisplay{
-						Lang:  "zh",
-						Value: program.ProgramName,
-					},
-				})
-			}
+	return nil
+}
+
+// appendMergedChannels 在频道列表后追加合并掉的其他清晰度版本，从未经合并的原始频道列表中查找
+func appendMergedChannels(channels, rawChannels []iptv.Channel) []iptv.Channel {
+	mergedIDs := make(map[string]struct{})
+	for _, channel := range channels {
+		for _, id := range channel.MergedChannelIDs {
+			mergedIDs[id] = struct{}{}
 		}
 	}
+	if len(mergedIDs) == 0 {
+		return channels
+	}
 
-	return &XmlEPG{
-		GeneratorInfoName: xmltvGenInfoName,
-		GeneratorInfoUrl:  xmltvGenInfoUrl,
-		Channels:          channels,
-		Programmes:        programmes,
+	result := slices.Clip(channels)
+	for _, channel := range rawChannels {
+		if _, ok := mergedIDs[channel.ChannelID]; ok {
+			result = append(result, channel)
+		}
 	}
+	return result
 }
 
-// updateEPG 更新缓存的节目单数据
-func updateEPG(ctx context.Context, iptvClient iptv.Client) error {
-	// 获取缓存的所有频道列表
-	channels := *channelsPtr.Load()
-	if len(channels) == 0 {
-		return errors.New("no channels")
+// selectMergedProgramLists 为频道列表中的每个频道选择节目单：优先使用频道自身的节目单，
+// 没有时使用合并掉的其他清晰度版本的节目单，其他版本的节目单不单独保留
+func selectMergedProgramLists(channels []iptv.Channel, chProgLists []iptv.ChannelProgramList) []iptv.ChannelProgramList {
+	chProgListMap := make(map[string]*iptv.ChannelProgramList, len(chProgLists))
+	for i := range chProgLists {
+		chProgListMap[chProgLists[i].ChannelId] = &chProgLists[i]
 	}
 
-	// 获取所有频道的节目单列表
-	allChProgramList, err := iptvClient.GetAllChannelProgramList(ctx, channels)
-	if err != nil {
-		return err
+	result := make([]iptv.ChannelProgramList, 0, len(channels))
+	for _, channel := range channels {
+		selected, ok := chProgListMap[channel.ChannelID]
+		if !ok || len(selected.DateProgramList) == 0 {
+			for _, id := range channel.MergedChannelIDs {
+				if chProgList, found := chProgListMap[id]; found && len(chProgList.DateProgramList) > 0 {
+					selected, ok = chProgList, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		chProgList := *selected
+		chProgList.ChannelId = channel.ChannelID
+		chProgList.ChannelName = channel.ChannelName
+		result = append(result, chProgList)
 	}
+	return result
+}
 
-	logger.Sugar().Infof("EPG data updated, total: %d.", len(allChProgramList))
-	// 更新缓存的频道列表
-	epgPtr.Store(&allChProgramList)
+// storeEPG 更新缓存的节目单
+func storeEPG(chProgLists []iptv.ChannelProgramList, updatedAt time.Time) {
+	epgPtr.Store(&chProgLists)
+	epgUpdatedAt.Store(updatedAt.UnixNano())
+}
 
+// loadEPG 获取缓存的节目单，尚未获取到数据时返回nil
+func loadEPG() []iptv.ChannelProgramList {
+	if epg := epgPtr.Load(); epg != nil {
+		return *epg
+	}
 	return nil
 }
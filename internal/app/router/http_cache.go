@@ -0,0 +1,205 @@
+package router
+
+import (
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelsLastModified 获取频道列表的更新时间，配置热加载后取热加载的时间，尚未获取到数据时返回零值
+func channelsLastModified(*gin.Context) time.Time {
+	updatedAt := channelsUpdatedAt.Load()
+	if updatedAt == 0 {
+		return time.Time{}
+	}
+	return unixNanoTime(max(updatedAt, configUpdatedAt.Load()))
+}
+
+// m3uLastModified 获取m3u直播源的更新时间，频道名称中追加了当前节目时内容随时间变化，返回零值以不使用缓存
+func m3uLastModified(c *gin.Context) time.Time {
+	if isNowTitleEnabled(c) {
+		return time.Time{}
+	}
+	return channelsLastModified(c)
+}
+
+// epgLastModified 获取节目单相关接口的更新时间，xmltv中的频道号、台标等信息来自频道列表，因此取两者（及配置热加载）中较晚的时间
+func epgLastModified(*gin.Context) time.Time {
+	updatedAt := max(channelsUpdatedAt.Load(), epgUpdatedAt.Load())
+	if updatedAt == 0 {
+		return time.Time{}
+	}
+	return unixNanoTime(max(updatedAt, configUpdatedAt.Load()))
+}
+
+// jsonEPGLastModified 获取JSON格式节目单的更新时间，未指定日期时查询的是当天的节目单，内容随日期变化；
+// 查询当前及下一个节目时内容随时间变化，返回零值以不使用缓存
+func jsonEPGLastModified(c *gin.Context) time.Time {
+	date, ok := c.GetQuery("date")
+	switch {
+	case !ok:
+		return notBeforeToday(epgLastModified(c), time.Local)
+	case strings.EqualFold(date, "now"):
+		return time.Time{}
+	default:
+		return epgLastModified(c)
+	}
+}
+
+// xmlEPGLastModified 获取xmltv节目单的更新时间，指定backDay时保留的日期随节目单所在时区的当前日期变化
+func xmlEPGLastModified(c *gin.Context) time.Time {
+	if getBackDay(c) > 0 {
+		return notBeforeToday(epgLastModified(c), epgLocation)
+	}
+	return epgLastModified(c)
+}
+
+// notBeforeToday 内容与当前日期有关时，更新时间不早于loc时区的当天零点，使跨天后ETag和Last-Modified随之变化
+func notBeforeToday(modTime time.Time, loc *time.Location) time.Time {
+	if modTime.IsZero() {
+		return modTime
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if modTime.Before(today) {
+		return today
+	}
+	return modTime
+}
+
+func unixNanoTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// conditionalGet 根据数据的更新时间设置ETag和Last-Modified，客户端缓存的内容仍有效时直接返回304
+func conditionalGet(lastModified func(c *gin.Context) time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		modTime := lastModified(c)
+		if modTime.IsZero() {
+			c.Next()
+			return
+		}
+
+		// 同一份数据在不同的请求参数和Host下生成的内容不同
+		etag := makeETag(modTime, c.Request)
+		c.Header("ETag", etag)
+		c.Header("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		if isNotModified(c.Request, etag, modTime) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		c.Next()
+	}
+}
+
+// makeETag 根据数据的更新时间和请求地址生成弱ETag，压缩与否不影响其取值
+func makeETag(modTime time.Time, req *http.Request) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d|%s|%s", modTime.UnixNano(), req.Host, req.URL.RequestURI())
+	return `W/"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// isNotModified 判断客户端缓存的内容是否仍有效，优先使用If-None-Match
+func isNotModified(req *http.Request, etag string, modTime time.Time) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		t, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// Last-Modified只精确到秒
+		return !modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// acceptsGzip 判断客户端是否接受gzip压缩的响应
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		// q=0表示不接受
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponse 客户端接受gzip时对响应内容进行压缩
+func gzipResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.close()
+		c.Next()
+	}
+}
+
+// gzipResponseWriter 在第一次写入内容时才开始压缩，没有响应内容（如304、404）时不输出gzip数据
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gzipWriter  *gzip.Writer
+	passThrough bool // 响应头已写入或内容已经过编码时，不再压缩
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if w.gzipWriter == nil && !w.passThrough {
+		if w.ResponseWriter.Written() || w.Header().Get("Content-Encoding") != "" {
+			w.passThrough = true
+		} else {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Del("Content-Length")
+			w.gzipWriter = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	if w.gzipWriter != nil {
+		return w.gzipWriter.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gzipWriter != nil {
+		_ = w.gzipWriter.Close()
+	}
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"iptv/internal/pkg/metrics"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var httpRequests = metrics.NewCounterVec("iptv_http_requests_total",
+	"Number of HTTP requests served by route and status code.", "path", "code")
+
+func init() {
+	metrics.NewGaugeFunc("iptv_channels_cache_age_seconds",
+		"Seconds since the cached channel list was last updated.", func() float64 {
+			return cacheAgeSeconds(channelsUpdatedAt.Load())
+		})
+	metrics.NewGaugeFunc("iptv_epg_cache_age_seconds",
+		"Seconds since the cached EPG was last updated.", func() float64 {
+			return cacheAgeSeconds(epgUpdatedAt.Load())
+		})
+	metrics.NewGaugeFunc("iptv_channels", "Number of cached channels.", func() float64 {
+		return float64(len(loadChannels()))
+	})
+	metrics.NewGaugeFunc("iptv_epg_channels", "Number of channels in the cached EPG.", func() float64 {
+		return float64(len(loadEPG()))
+	})
+}
+
+// cacheAgeSeconds 获取缓存数据距上次更新的秒数，尚未获取到数据时返回NaN（不输出该指标）
+func cacheAgeSeconds(updatedAt int64) float64 {
+	if updatedAt == 0 {
+		return math.NaN()
+	}
+	return time.Since(time.Unix(0, updatedAt)).Seconds()
+}
+
+// countRequests 按路由及状态码统计请求次数，未匹配到路由的请求统一记为unmatched，避免标签数量无限增长
+func countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		path := c.FullPath()
+		if path == "" {
+			path = "unmatched"
+		}
+		httpRequests.Inc(path, strconv.Itoa(c.Writer.Status()))
+	}
+}
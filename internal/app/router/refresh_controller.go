@@ -0,0 +1,238 @@
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"iptv/internal/app/iptv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 刷新的数据范围
+const (
+	RefreshTargetAll      = "all"
+	RefreshTargetChannels = "channels"
+	RefreshTargetEPG      = "epg"
+	// 只刷新当天（及未来）的节目单，适合频繁执行
+	RefreshTargetEPGToday = "epg-today"
+)
+
+// 刷新任务的状态
+const (
+	RefreshStatusRunning   = "running"
+	RefreshStatusSucceeded = "succeeded"
+	RefreshStatusFailed    = "failed"
+)
+
+// 保留的已完成任务数量，供查询任务进度
+const maxFinishedRefreshJobs = 20
+
+var (
+	// 管理接口的访问令牌
+	adminToken string
+
+	refresher *refreshRunner
+)
+
+// RefreshJob 刷新任务
+type RefreshJob struct {
+	ID         string     `json:"id"`                   // 任务ID
+	Target     string     `json:"target"`               // 刷新的数据范围：all、channels、epg、epg-today
+	Trigger    string     `json:"trigger"`              // 触发方式：startup、schedule、admin
+	Status     string     `json:"status"`               // 任务状态：running、succeeded、failed
+	Stage      string     `json:"stage,omitempty"`      // 正在刷新的数据：channels、epg
+	Error      string     `json:"error,omitempty"`      // 失败时的错误信息
+	StartedAt  time.Time  `json:"startedAt"`            // 开始时间
+	FinishedAt *time.Time `json:"finishedAt,omitempty"` // 结束时间
+
+	done chan struct{} // 任务结束时关闭
+}
+
+// refreshRunner 执行刷新任务，同一时间最多只有一个任务在执行，启动、定时及手动触发的刷新不会重叠
+type refreshRunner struct {
+	ctx        context.Context
+	iptvClient iptv.Client
+
+	mu      sync.Mutex
+	seq     int
+	current *RefreshJob            // 正在执行的任务
+	jobs    map[string]*RefreshJob // 正在执行及最近完成的任务
+	order   []string               // 任务ID，按开始时间排序
+}
+
+func newRefreshRunner(ctx context.Context, iptvClient iptv.Client) *refreshRunner {
+	return &refreshRunner{
+		ctx:        ctx,
+		iptvClient: iptvClient,
+		jobs:       make(map[string]*RefreshJob),
+	}
+}
+
+// start 在后台启动刷新任务。已有任务在执行时不启动新任务，返回正在执行的任务及false
+func (r *refreshRunner) start(target, trigger string) (RefreshJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil {
+		return *r.current, false
+	}
+	return r.startLocked(target, trigger), true
+}
+
+// startWhenIdle 等待正在执行的任务结束后再启动刷新任务，等待期间ctx被取消时返回错误
+func (r *refreshRunner) startWhenIdle(ctx context.Context, target, trigger string) (RefreshJob, error) {
+	for {
+		r.mu.Lock()
+		if r.current == nil {
+			job := r.startLocked(target, trigger)
+			r.mu.Unlock()
+			return job, nil
+		}
+		done := r.current.done
+		r.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return RefreshJob{}, ctx.Err()
+		}
+	}
+}
+
+// startLocked 创建并在后台执行刷新任务，调用方需持有r.mu
+func (r *refreshRunner) startLocked(target, trigger string) RefreshJob {
+	r.seq++
+	job := &RefreshJob{
+		ID:        strconv.FormatInt(time.Now().Unix(), 36) + "-" + strconv.Itoa(r.seq),
+		Target:    target,
+		Trigger:   trigger,
+		Status:    RefreshStatusRunning,
+		StartedAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	r.current = job
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		r.execute(job)
+	}()
+	return *job
+}
+
+// get 查询刷新任务
+func (r *refreshRunner) get(id string) (RefreshJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return RefreshJob{}, false
+	}
+	return *job, true
+}
+
+// execute 执行刷新任务，频道列表更新失败时仍会尝试更新节目单
+func (r *refreshRunner) execute(job *RefreshJob) {
+	logger.Info("Start executing the refresh job.", zap.String("id", job.ID),
+		zap.String("target", job.Target), zap.String("trigger", job.Trigger))
+
+	var errs []error
+	if job.Target == RefreshTargetAll || job.Target == RefreshTargetChannels {
+		r.setStage(job, RefreshTargetChannels)
+		if err := updateChannelsWithRetry(r.ctx, r.iptvClient); err != nil {
+			logger.Error("Failed to update channel list.", zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+	if job.Target == RefreshTargetAll || job.Target == RefreshTargetEPG || job.Target == RefreshTargetEPGToday {
+		r.setStage(job, RefreshTargetEPG)
+		if err := updateEPGWithRetry(r.ctx, r.iptvClient, job.Target == RefreshTargetEPGToday); err != nil {
+			logger.Error("Failed to update EPG.", zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	r.finish(job, errors.Join(errs...))
+	logger.Info("The refresh job has been completed.", zap.String("id", job.ID))
+}
+
+func (r *refreshRunner) setStage(job *RefreshJob, stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.Stage = stage
+}
+
+// finish 记录任务的结果，并清理过多的已完成任务
+func (r *refreshRunner) finish(job *RefreshJob, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Stage = ""
+	job.Status = RefreshStatusSucceeded
+	if err != nil {
+		job.Status = RefreshStatusFailed
+		job.Error = err.Error()
+	}
+	r.current = nil
+	close(job.done)
+
+	for len(r.order) > maxFinishedRefreshJobs {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// adminAuth 校验管理接口的访问令牌
+func adminAuth(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// PostRefresh 手动触发刷新频道列表和（或）节目单，已有任务在执行时返回该任务
+func PostRefresh(c *gin.Context) {
+	target := c.DefaultQuery("target", RefreshTargetAll)
+	if target != RefreshTargetAll && target != RefreshTargetChannels && target != RefreshTargetEPG && target != RefreshTargetEPGToday {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target must be one of all, channels, epg, epg-today"})
+		return
+	}
+
+	job, started := refresher.start(target, "admin")
+	c.Header("Location", "/admin/refresh/"+job.ID)
+	if !started {
+		// 正在执行的任务已包含所请求的数据时直接复用，否则需等待其结束后再重试
+		if job.Target == RefreshTargetAll || job.Target == target ||
+			(job.Target == RefreshTargetEPG && target == RefreshTargetEPGToday) {
+			c.JSON(http.StatusOK, job)
+		} else {
+			c.JSON(http.StatusConflict, job)
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetRefreshJob 查询刷新任务的进度
+func GetRefreshJob(c *gin.Context) {
+	job, ok := refresher.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
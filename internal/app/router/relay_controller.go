@@ -0,0 +1,45 @@
+package router
+
+import (
+	"errors"
+	"iptv/internal/app/relay"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 内置的组播转单播服务，未启用时为nil
+var multicastRelay *relay.Relay
+
+// GetRTPStream 将RTP组播流去掉RTP头后以HTTP输出
+func GetRTPStream(c *gin.Context) {
+	serveMulticastStream(c, true)
+}
+
+// GetUDPStream 将UDP组播流原样以HTTP输出
+func GetUDPStream(c *gin.Context) {
+	serveMulticastStream(c, false)
+}
+
+func serveMulticastStream(c *gin.Context, stripRTP bool) {
+	addr := c.Param("addr")
+	err := multicastRelay.Serve(c.Writer, c.Request, addr, stripRTP)
+	if err == nil {
+		return
+	}
+
+	logger.Warn("Failed to relay the multicast stream.", zap.String("addr", addr), zap.Error(err))
+	// 已经开始输出数据流时无法再修改响应状态
+	if c.Writer.Written() {
+		return
+	}
+	switch {
+	case errors.Is(err, relay.ErrInvalidAddress):
+		c.Status(http.StatusBadRequest)
+	case errors.Is(err, relay.ErrNoData):
+		c.Status(http.StatusGatewayTimeout)
+	default:
+		c.Status(http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"iptv/internal/app/config"
+	"iptv/internal/app/iptv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// reloadableConfig 支持热加载的配置，整体进行替换
+type reloadableConfig struct {
+	udpxyURLs      map[string]string  // udpxy的名称及对应的URL
+	catchupSources map[string]string  // 回看请求的参数
+	channelRules   *iptv.ChannelRules // 频道的过滤、分组及台标匹配规则
+}
+
+var (
+	reloadablePtr atomic.Pointer[reloadableConfig]
+	// 配置的热加载时间（UnixNano），热加载后直播源等接口的内容随之变化
+	configUpdatedAt atomic.Int64
+
+	// serve命令中-u参数配置的udpxy地址，配置文件中未设置udpxy时使用
+	udpxyURLFlag string
+	// 获取频道列表时使用的规则可在运行时替换的IPTV客户端
+	channelRulesUpdater iptv.ChannelRulesUpdater
+
+	// 未经合并及自定义处理的频道列表，热加载后据此重新识别频道的分组及台标
+	rawChannelsPtr atomic.Pointer[[]iptv.Channel]
+	// 保证频道列表的更新与热加载时的重新处理不会交错
+	channelsMu sync.Mutex
+)
+
+// newReloadableConfig 从配置中提取支持热加载的部分
+func newReloadableConfig(conf *config.Config) *reloadableConfig {
+	udpxyURLCfg := udpxyURLFlag
+	if conf.Udpxy != "" {
+		udpxyURLCfg = conf.Udpxy
+	}
+	return &reloadableConfig{
+		udpxyURLs:      parseUdpxyURLs(udpxyURLCfg),
+		catchupSources: conf.Catchup.Sources,
+		channelRules:   conf.ChannelRules(),
+	}
+}
+
+// loadReloadable 获取当前生效的可热加载配置
+func loadReloadable() *reloadableConfig {
+	if rc := reloadablePtr.Load(); rc != nil {
+		return rc
+	}
+	return &reloadableConfig{}
+}
+
+// ReloadConfig 热加载已校验的配置：替换频道的过滤、分组及台标规则，回看请求参数以及udpxy地址，
+// 并立即重新识别缓存频道的分组及台标。其他配置项仍需重启服务后生效
+func ReloadConfig(conf *config.Config) {
+	rc := newReloadableConfig(conf)
+	reloadablePtr.Store(rc)
+	if channelRulesUpdater != nil {
+		channelRulesUpdater.UpdateChannelRules(rc.channelRules)
+	}
+
+	// 使用新的规则重新处理缓存的频道列表，频道列表本身的更新时间不变
+	channelsMu.Lock()
+	if rawChannels := rawChannelsPtr.Load(); rawChannels != nil {
+		storeChannelsLocked(*rawChannels, unixNanoTime(channelsUpdatedAt.Load()))
+	}
+	channelsMu.Unlock()
+	configUpdatedAt.Store(time.Now().UnixNano())
+
+	logger.Info("The config has been reloaded.", zap.Int("channels", len(loadChannels())),
+		zap.Int("udpxy", len(rc.udpxyURLs)), zap.Int("catchupSources", len(rc.catchupSources)))
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"iptv/internal/app/config"
 	"iptv/internal/app/iptv"
-	"iptv/internal/app/iptv/hwctc"
+	"iptv/internal/app/relay"
+	"iptv/internal/pkg/cron"
+	"iptv/internal/pkg/metrics"
 	"iptv/internal/pkg/util"
 	"net/http"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
@@ -20,11 +23,19 @@ import (
 var (
 	logger *zap.Logger
 
-	udpxyURLs      map[string]string
-	catchupSources map[string]string
+	epgRetentionDays int
+
+	// 定时刷新、刷新任务等后台协程，ctx取消后通过Wait等待其退出
+	backgroundTasks sync.WaitGroup
 )
 
-func NewEngine(ctx context.Context, conf *config.Config, interval time.Duration, udpxyURLCfg string) (*gin.Engine, error) {
+// Wait 等待NewEngine启动的后台协程全部退出，需先取消传给NewEngine的ctx。
+// 再次调用NewEngine前需等待，否则后台协程可能读取到被替换的全局配置
+func Wait() {
+	backgroundTasks.Wait()
+}
+
+func NewEngine(ctx context.Context, conf *config.Config, httpClient *http.Client, interval time.Duration, udpxyURLCfg, dataDirCfg string) (*gin.Engine, error) {
 	// L()：获取全局logger
 	logger = zap.L()
 
@@ -36,26 +47,90 @@ func NewEngine(ctx context.Context, conf *config.Config, interval time.Duration,
 		return nil, err
 	}
 
+	// 设置缓存数据的存放目录
+	dataDir = dataDirCfg
+	if dataDir == "" {
+		dataDir = path.Join(currDir, "data")
+	}
+
 	// 创建IPTV客户端
-	iptvClient, err := newIPTVClient(conf)
+	iptvClient, err := newIPTVClient(conf, httpClient)
 	if err != nil {
 		return nil, err
 	}
 
+	// 缓存节目单保留的历史天数、时区及语言代码
+	epgRetentionDays = conf.EPG.RetentionDays
+	epgLocation = conf.EPG.Location
+	epgLang = conf.EPG.Lang
+
+	// 缓存频道的合并、重命名、频道号及排序配置
+	channelMerger = conf.ChMerger
+	channelCustomizer = conf.ChCustomizer
+
+	// 缓存支持热加载的配置：频道的过滤、分组及台标规则，回看请求参数以及udpxy地址
+	udpxyURLFlag = udpxyURLCfg
+	reloadablePtr.Store(newReloadableConfig(conf))
+	configUpdatedAt.Store(0)
+	channelRulesUpdater, _ = iptvClient.(iptv.ChannelRulesUpdater)
+
+	// 缓存刷新失败时的重试配置
+	refreshRetries = conf.Schedule.Retries
+	refreshBackoff = conf.Schedule.Backoff
+	refreshMaxBackoff = conf.Schedule.MaxBackoff
+
 	// 执行初始化操作
+	refresher = newRefreshRunner(ctx, iptvClient)
 	err = initData(ctx, iptvClient)
 	if err != nil {
 		return nil, err
 	}
 
-	// 执行定时任务
-	Schedule(ctx, iptvClient, interval)
+	// 执行定时任务：频道列表和节目单可分别配置执行计划，均未配置时按interval一并刷新，当天的节目单可另外配置执行计划
+	channelSchedules, epgSchedules := conf.Schedule.ChannelSchedules, conf.Schedule.EPGSchedules
+	if len(channelSchedules) == 0 && len(epgSchedules) == 0 {
+		channelSchedules = []cron.Schedule{cron.Every(interval)}
+		Schedule(ctx, RefreshTargetAll, channelSchedules, conf.Schedule.Jitter)
+	} else {
+		if len(channelSchedules) == 0 {
+			channelSchedules = []cron.Schedule{cron.Every(interval)}
+		}
+		if len(epgSchedules) == 0 {
+			epgSchedules = []cron.Schedule{cron.Every(interval)}
+		}
+		Schedule(ctx, RefreshTargetChannels, channelSchedules, conf.Schedule.Jitter)
+		Schedule(ctx, RefreshTargetEPG, epgSchedules, conf.Schedule.Jitter)
+	}
+	// 频繁刷新当天的节目单时只获取当天的数据，避免每次都查询全部的回看日期
+	Schedule(ctx, RefreshTargetEPGToday, conf.Schedule.EPGTodaySchedules, conf.Schedule.Jitter)
 
-	// 缓存udpxy配置
-	udpxyURLs = parseUdpxyURLs(udpxyURLCfg)
+	// 缓存频道列表的视图配置
+	channelViews = conf.ChViews
 
 	// 缓存回看请求参数配置
-	catchupSources = conf.Catchup.Sources
+	catchupMode = conf.Catchup.Mode
+	catchupHeaders = conf.Headers
+	sessionProvider, _ = iptvClient.(iptv.SessionProvider)
+
+	// 缓存健康检查配置，频道列表的最大缓存时长缺省为刷新周期的2倍
+	maxChannelAge = 2 * schedulePeriod(channelSchedules, time.Now().In(epgLocation))
+	if conf.Health != nil && conf.Health.MaxChannelAge > 0 {
+		maxChannelAge = conf.Health.MaxChannelAge
+	}
+	statusProvider, _ = iptvClient.(iptv.StatusProvider)
+
+	// 创建内置的组播转单播服务
+	if conf.Relay != nil && conf.Relay.Enable {
+		if multicastRelay, err = relay.NewRelay(conf.Relay.InterfaceName, conf.Relay.Timeout); err != nil {
+			return nil, err
+		}
+		backgroundTasks.Add(1)
+		go func(multicastRelay *relay.Relay) {
+			defer backgroundTasks.Done()
+			<-ctx.Done()
+			multicastRelay.Close()
+		}(multicastRelay)
+	}
 
 	// 创建 Gin 路由引擎
 	r := gin.New()
@@ -64,18 +139,37 @@ func NewEngine(ctx context.Context, conf *config.Config, interval time.Duration,
 	r.Use(ginzap.Ginzap(logger, "", false))
 	r.Use(ginzap.RecoveryWithZap(logger, true))
 
+	// 统计各接口的请求次数
+	r.Use(countRequests())
+
+	// 直播源和EPG接口支持条件请求（ETag、Last-Modified）及gzip压缩
+	channelCache := conditionalGet(channelsLastModified)
+	xmlEPGCache := conditionalGet(xmlEPGLastModified)
+
 	// 查询直播源-m3u格式
-	r.GET("/channel/m3u", GetM3UData)
+	r.GET("/channel/m3u", gzipResponse(), conditionalGet(m3uLastModified), GetM3UData)
 	// 查询直播源-txt格式
-	r.GET("/channel/txt", GetTXTData)
+	r.GET("/channel/txt", gzipResponse(), channelCache, GetTXTData)
 	// 查询直播源-pls格式
-	r.GET("/channel/pls", GetPLSData)
+	r.GET("/channel/pls", gzipResponse(), channelCache, GetPLSData)
 
 	// 查询EPG-json格式
-	r.GET("/epg/json", GetJsonEPG)
+	r.GET("/epg/json", gzipResponse(), conditionalGet(jsonEPGLastModified), GetJsonEPG)
 	// 查询EPG-xml格式
-	r.GET("/epg/xml", GetXmlEPG)
-	r.GET("/epg/xml.gz", GetXmlEPGWithGzip)
+	r.GET("/epg/xml", gzipResponse(), xmlEPGCache, GetXmlEPG)
+	r.GET("/epg/xml.gz", xmlEPGCache, GetXmlEPGWithGzip)
+	// 查询频道当前及下一个节目
+	r.GET("/epg/now", gzipResponse(), GetNowNextEPG)
+
+	// 代理频道的回看请求
+	r.GET("/catchup/:channelID", GetCatchupStream)
+	r.GET("/catchup/:channelID/proxy", GetCatchupResource)
+
+	// 组播转单播
+	if multicastRelay != nil {
+		r.GET("/rtp/:addr", GetRTPStream)
+		r.GET("/udp/:addr", GetUDPStream)
+	}
 
 	// 查询频道logo
 	r.Static("/logo", path.Join(currDir, "logos"))
@@ -83,6 +177,24 @@ func NewEngine(ctx context.Context, conf *config.Config, interval time.Duration,
 	// 查询直播配置接口
 	r.GET("/config/lives", GetLivesConfig)
 
+	// 健康检查及数据的更新状态
+	r.GET("/healthz", GetHealthz)
+	r.GET("/status", GetStatus)
+
+	// 管理接口：手动刷新频道列表和节目单，未配置访问令牌时不启用
+	adminToken = ""
+	if conf.Admin != nil {
+		adminToken = conf.Admin.Token
+	}
+	if adminToken != "" {
+		admin := r.Group("/admin", adminAuth)
+		admin.POST("/refresh", PostRefresh)
+		admin.GET("/refresh/:id", GetRefreshJob)
+	}
+
+	// 查询Prometheus监控指标
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	return r, nil
 }
 
@@ -111,28 +223,31 @@ func parseUdpxyURLs(udpxyURLCfg string) map[string]string {
 
 // initData 初始化数据
 func initData(ctx context.Context, iptvClient iptv.Client) error {
+	// 优先加载磁盘中缓存的数据，并在后台进行首次更新
+	if loadCachedData() {
+		refresher.start(RefreshTargetAll, "startup")
+		return nil
+	}
+
 	// 更新频道列表数据
-	if err := updateChannelsWithRetry(ctx, iptvClient, 3); err != nil {
+	if err := updateChannelsWithRetry(ctx, iptvClient); err != nil {
 		return err
 	}
 
 	// 更新节目单
-	if err := updateEPG(ctx, iptvClient); err != nil {
+	if err := updateEPG(ctx, iptvClient, false); err != nil {
 		logger.Error("Failed to update EPG.", zap.Error(err))
 	}
 	return nil
 }
 
 // newIPTVClient 读取配置文件并创建IPTV客户端
-func newIPTVClient(conf *config.Config) (iptv.Client, error) {
+func newIPTVClient(conf *config.Config, httpClient *http.Client) (iptv.Client, error) {
 	// 校验配置文件
 	if err := conf.Validate(); err != nil {
 		return nil, err
 	}
 
-	// 创建IPTV客户端
-	return hwctc.NewClient(&http.Client{
-		Timeout: 10 * time.Second,
-	}, conf.HWCTC, conf.Key, conf.ServerHost, conf.Headers,
-		conf.ChExcludeRule, conf.ChGroupRulesList, conf.ChLogoRuleList)
+	// 根据配置的平台类型创建IPTV客户端
+	return conf.NewIPTVClient(httpClient)
 }
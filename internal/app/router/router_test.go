@@ -0,0 +1,987 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"iptv/internal/app/config"
+	"iptv/internal/app/iptv"
+	_ "iptv/internal/app/iptv/hwctc" // 注册hw平台
+	"iptv/internal/app/iptv/hwctc/hwctctest"
+	"iptv/internal/pkg/cron"
+	"iptv/internal/pkg/metrics"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+key: "12345678"
+serverHost: %s
+chExcludeRule: "^.*?(画中画|单音轨|-体验|\\(测试\\)|直播室\\d+)"
+logos:
+  - rule: '^(.+?)-(.+?)(\(?标清\)?|\(?高清\)?|\(?超清\)?)?$'
+    name: '$G1$G2'
+  - rule: '^(.+?)(\(?标清\)?|\(?高清\)?|\(?超清\)?|\(?VIP\)?)?$'
+    name: '$G1'
+catchup:
+  sources:
+    0: 'playseek=${(b)yyyyMMddHHmmss}-${(e)yyyyMMddHHmmss}'
+    2: 'starttime={utc:YmdHMS}&endtime={utcend:YmdHMS}'
+  mode: proxy
+epg:
+  retentionDays: 8
+views:
+  hd:
+    include:
+      - 'definition:^(HD|4K)$'
+    exclude:
+      - 'catchup:^0$'
+chMerge:
+  enable: true
+  keyRules:
+    - rule: '^(CCTV-\d+\+?)'
+      name: '$G1'
+chCustom:
+  rename:
+    - rule: '^(CGTN)$'
+      name: '$G1英语'
+  numbers:
+    '1002': '5'
+  pins:
+    - channel: '湖南卫视'
+      position: 1
+admin:
+  token: secret
+schedule:
+  epg:
+    - '30 4 * * *'
+  epgToday:
+    - '0 * * * *'
+  jitter: 1m
+platform: hwctc
+hwctc:
+  ip: 10.0.0.2
+  epgRateLimit: -1
+  userID: test_user
+  stbType: EC6108V9U_pub_jljlt
+  stbVersion: V100R003C20LJLD18B010
+  conntype: dhcp
+  stbID: 00100599007060400000BC6202A5A7A7
+  mac: BC:62:02:A5:A7:A7
+  softwareVersion: V100R003C20LJLD18B010
+`
+
+// newTestEngine 使用模拟的IPTV中间件创建路由引擎，返回的stop函数用于停止引擎的后台协程
+func newTestEngine(t *testing.T) (*gin.Engine, *hwctctest.Server, string, func()) {
+	t.Helper()
+	server := hwctctest.NewServer(hwctctest.Options{
+		Key:    "12345678",
+		UserID: "test_user",
+	})
+	t.Cleanup(server.Close)
+
+	var conf config.Config
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf(testConfig, server.Host())), &conf); err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	dataDir := t.TempDir()
+	engine, stop := startTestEngine(t, &conf, dataDir)
+	return engine, server, dataDir, stop
+}
+
+// startTestEngine 创建路由引擎，返回的stop函数取消ctx并等待后台协程退出，测试结束时自动调用。
+// NewEngine会替换包内的全局配置，再次创建引擎前需先调用stop
+func startTestEngine(t *testing.T, conf *config.Config, dataDir string) (*gin.Engine, func()) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := sync.OnceFunc(func() {
+		cancel()
+		Wait()
+	})
+	t.Cleanup(stop)
+
+	engine, err := NewEngine(ctx, conf, &http.Client{}, time.Hour, "", dataDir)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return engine, stop
+}
+
+func doRequest(engine *gin.Engine, target string) *httptest.ResponseRecorder {
+	return doRequestWithHeader(engine, target, nil)
+}
+
+func doRequestWithHeader(engine *gin.Engine, target string, header map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+// metricValue 获取缺省注册表中指标的取值，指标不存在时返回0
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := metrics.DefaultRegistry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("invalid value of metric %s: %s", series, value)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestEngine(t *testing.T) {
+	// 指标是全局的，其他测试（或-count多次执行）也会累加，因此比较前后的差值
+	const authSeries = `iptv_refresh_total{stage="auth",strategy="",result="success"}`
+	authTotal := metricValue(t, authSeries)
+
+	engine, server, dataDir, _ := newTestEngine(t)
+
+	t.Run("m3u", func(t *testing.T) {
+		w := doRequest(engine, "/channel/m3u")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		body := w.Body.String()
+		if !strings.HasPrefix(body, "#EXTM3U") || !strings.Contains(body, "CCTV-1高清") {
+			t.Errorf("unexpected m3u content: %s", body)
+		}
+		if strings.Contains(body, "画中画") {
+			t.Errorf("excluded channel found in m3u content: %s", body)
+		}
+		if !strings.Contains(body, `catchup-source="http://example.com/catchup/1002?csFormat=0&playseek=`) {
+			t.Errorf("catchup source not found in m3u content: %s", body)
+		}
+	})
+
+	t.Run("txt", func(t *testing.T) {
+		w := doRequest(engine, "/channel/txt")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "湖南卫视,") {
+			t.Errorf("unexpected txt response: %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		w := doRequest(engine, "/channel/m3u")
+		body := w.Body.String()
+		// 标清版本作为高清频道的备用地址
+		if strings.Contains(body, `tvg-id="1007"`) || strings.Count(body, `tvg-id="1001"`) != 2 {
+			t.Errorf("channel variants are not merged: %s", body)
+		}
+		if !strings.Contains(body, "igmp://239.93.0.101:5140") {
+			t.Errorf("backup URL not found in m3u content: %s", body)
+		}
+	})
+
+	t.Run("url mode", func(t *testing.T) {
+		w := doRequest(engine, "/channel/txt?urlMode=all")
+		if n := strings.Count(w.Body.String(), "湖南卫视,"); n != 2 {
+			t.Errorf("got %d lines for channel with 2 URLs, want 2: %s", n, w.Body.String())
+		}
+
+		w = doRequest(engine, "/channel/txt?urlMode=hash")
+		want := "CCTV-1高清,igmp://239.93.0.1:5140#rtsp://" + server.Host()
+		if !strings.Contains(w.Body.String(), want) || !strings.Contains(w.Body.String(), "#igmp://239.93.0.101:5140\n") {
+			t.Errorf("unexpected txt content for hash mode: %s", w.Body.String())
+		}
+
+		// m3u和pls中hash同all
+		for _, format := range []string{"m3u", "pls"} {
+			all := doRequest(engine, "/channel/"+format+"?urlMode=all").Body.String()
+			if hash := doRequest(engine, "/channel/"+format+"?urlMode=hash").Body.String(); hash != all || !strings.Contains(all, "igmp://239.93.0.101:5140") {
+				t.Errorf("got %s content for hash mode:\n%s\nwant:\n%s", format, hash, all)
+			}
+		}
+
+		for _, format := range []string{"m3u", "txt", "pls"} {
+			if w = doRequest(engine, "/channel/"+format+"?urlMode=foo"); w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d for unknown url mode of %s, want 400", w.Code, format)
+			}
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		w := doRequest(engine, "/channel/m3u")
+		lines := strings.Split(w.Body.String(), "\n")
+		if len(lines) < 2 || !strings.HasPrefix(lines[1], `#EXTINF:-1 tvg-id="1002" tvg-chno="5"`) {
+			t.Errorf("pinned channel is not the first one: %s", w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), ",CGTN英语\n") {
+			t.Errorf("renamed channel not found in m3u content: %s", w.Body.String())
+		}
+	})
+
+	t.Run("view", func(t *testing.T) {
+		w := doRequest(engine, "/channel/txt?view=hd")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "CCTV-1高清,") || strings.Contains(body, "湖南卫视") || strings.Contains(body, "CGTN") {
+			t.Errorf("unexpected txt content for view: %s", body)
+		}
+
+		if w = doRequest(engine, "/channel/m3u?view=notexist"); w.Code != http.StatusNotFound {
+			t.Errorf("got status %d for unknown view, want 404", w.Code)
+		}
+	})
+
+	t.Run("epg json", func(t *testing.T) {
+		w := doRequest(engine, "/epg/json?ch=CCTV-1高清")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		var resp ChannelDateJsonEPG
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp.EPGData) != hwctctest.ProgramsPerDay {
+			t.Fatalf("got %d programs, want %d", len(resp.EPGData), hwctctest.ProgramsPerDay)
+		}
+		if want := hwctctest.ProgramName("1001", time.Now(), 0); resp.EPGData[0].Title != want {
+			t.Errorf("got program %q, want %q", resp.EPGData[0].Title, want)
+		}
+	})
+
+	t.Run("epg json lookup", func(t *testing.T) {
+		today := time.Now()
+		for _, ch := range []string{"1001", "1", "cctv1综合", "CCTV-1"} {
+			var resp ChannelDateJsonEPG
+			w := doRequest(engine, "/epg/json?ch="+url.QueryEscape(ch))
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: failed to parse response: %v", ch, err)
+			}
+			if want := hwctctest.ProgramName("1001", today, 0); len(resp.EPGData) == 0 || resp.EPGData[0].Title != want {
+				t.Errorf("%s: got %+v, want program %q", ch, resp.EPGData, want)
+			}
+		}
+
+		// 多个频道、日期范围
+		var resps []ChannelDateJsonEPG
+		dateRange := today.AddDate(0, 0, -1).Format("2006-01-02") + "~" + today.Format("20060102")
+		w := doRequest(engine, "/epg/json?ch=1001,1002&date="+dateRange)
+		if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resps) != 2 || len(resps[1].EPGData) != 2*hwctctest.ProgramsPerDay || resps[1].EPGData[0].Date == "" {
+			t.Errorf("unexpected response for multiple channels and dates: %+v", resps)
+		}
+
+		// 当前及下一个节目
+		var resp ChannelDateJsonEPG
+		w = doRequest(engine, "/epg/json?ch=1002&date=now")
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp.EPGData) == 0 || resp.EPGData[0].Title == placeholderProgramName {
+			t.Errorf("unexpected response for now: %+v", resp)
+		}
+		// 内容随时间变化，不使用缓存
+		if w.Header().Get("ETag") != "" || w.Header().Get("Last-Modified") != "" {
+			t.Errorf("now/next response should not be cached: %v", w.Header())
+		}
+
+		// 过短的名称或不在词的边界处的前缀不做近似匹配
+		for _, ch := range []string{"c", "cc", "湖南", "cctv", "CGTNews"} {
+			var resp ChannelDateJsonEPG
+			w := doRequest(engine, "/epg/json?ch="+url.QueryEscape(ch))
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: failed to parse response: %v", ch, err)
+			}
+			if len(resp.EPGData) == 0 || resp.EPGData[0].Title != placeholderProgramName {
+				t.Errorf("%s: got %+v, want placeholder programs", ch, resp.EPGData[0])
+			}
+		}
+		for _, ch := range []string{"湖南卫视高清", "CGTN英语", "cctv1hd"} {
+			if channel := findChannel(loadChannels(), ch); channel == nil {
+				t.Errorf("%s: channel not found", ch)
+			}
+		}
+
+		// 没有节目单的频道使用占位节目
+		w = doRequest(engine, "/epg/json?ch=notexist")
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp.EPGData) != 24 || resp.EPGData[0].Title != placeholderProgramName {
+			t.Errorf("unexpected placeholder response: %+v", resp)
+		}
+	})
+
+	t.Run("epg now", func(t *testing.T) {
+		now := time.Now()
+		// 模拟的节目单每3小时一个节目
+		want := hwctctest.ProgramName("1001", now, now.Hour()/3)
+
+		var resp []ChannelNowNextEPG
+		w := doRequest(engine, "/epg/now?ch=1001,1003")
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(resp) != 2 || resp[0].Now == nil || resp[0].Now.ProgramName != want {
+			t.Fatalf("unexpected now/next response: %s", w.Body.String())
+		}
+		// 不支持回看的频道没有节目单
+		if resp[1].ChannelID != "1003" || resp[1].Now != nil || resp[1].Next != nil {
+			t.Errorf("unexpected now/next for channel without EPG: %+v", resp[1])
+		}
+
+		w = doRequest(engine, "/channel/m3u?nowTitle=true")
+		if !strings.Contains(w.Body.String(), ",CCTV-1高清 - "+want+"\n") {
+			t.Errorf("current programme not found in m3u content: %s", w.Body.String())
+		}
+		if w.Header().Get("ETag") != "" {
+			t.Errorf("m3u with current programme should not be cached")
+		}
+	})
+
+	t.Run("epg xml", func(t *testing.T) {
+		w := doRequest(engine, "/epg/xml")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		var tv struct {
+			Channels []struct {
+				ID  string `xml:"id,attr"`
+				LCN string `xml:"lcn"`
+			} `xml:"channel"`
+			Programmes []struct {
+				Start string `xml:"start,attr"`
+			} `xml:"programme"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &tv); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(tv.Channels) != 2 || len(tv.Programmes) == 0 {
+			t.Fatalf("got %d channels and %d programmes", len(tv.Channels), len(tv.Programmes))
+		}
+		// 频道号使用自定义后的值
+		if tv.Channels[0].ID != "1002" || tv.Channels[0].LCN != "5" {
+			t.Errorf("unexpected channel: %+v", tv.Channels[0])
+		}
+		// 缺省使用Asia/Shanghai时区
+		if start := tv.Programmes[0].Start; !strings.HasSuffix(start, " +0800") {
+			t.Errorf("unexpected programme start time: %s", start)
+		}
+	})
+
+	t.Run("epg xml.gz", func(t *testing.T) {
+		w := doRequest(engine, "/epg/xml.gz?backDay=1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		gzipReader, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip response: %v", err)
+		}
+		content, err := io.ReadAll(gzipReader)
+		if err != nil {
+			t.Fatalf("failed to read gzip response: %v", err)
+		}
+		// 与未压缩的接口返回相同的内容
+		if w = doRequest(engine, "/epg/xml?backDay=1"); w.Body.String() != string(content) {
+			t.Errorf("xml.gz content differs from xml content")
+		}
+		if w = doRequest(engine, "/epg/xml"); len(w.Body.String()) <= len(content) {
+			t.Errorf("backDay does not limit the programmes")
+		}
+		// 超出保留天数的backDay按保留天数处理
+		all := doRequest(engine, "/epg/xml?backDay=8").Body.String()
+		if w = doRequest(engine, "/epg/xml?backDay=100000"); w.Body.String() != all {
+			t.Errorf("backDay is not clamped to the retention days")
+		}
+
+		// 接受gzip的客户端直接获取压缩后的内容
+		for i := 0; i < 2; i++ {
+			w = doRequestWithHeader(engine, "/epg/xml?backDay=1", map[string]string{"Accept-Encoding": "gzip"})
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("got Content-Encoding %q, want gzip", w.Header().Get("Content-Encoding"))
+			}
+			if gzipReader, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatalf("failed to read gzip response: %v", err)
+			}
+			if gzipContent, err := io.ReadAll(gzipReader); err != nil || string(gzipContent) != string(content) {
+				t.Errorf("gzip content differs from xml content: %v", err)
+			}
+		}
+	})
+
+	t.Run("conditional get", func(t *testing.T) {
+		for _, target := range []string{"/channel/m3u", "/epg/xml"} {
+			w := doRequest(engine, target)
+			etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+			if etag == "" || lastModified == "" {
+				t.Fatalf("%s: ETag or Last-Modified not found: %v", target, w.Header())
+			}
+			if w = doRequestWithHeader(engine, target, map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("%s: got status %d with If-None-Match, want 304", target, w.Code)
+			}
+			if w = doRequestWithHeader(engine, target, map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+				t.Errorf("%s: got status %d with If-Modified-Since, want 304", target, w.Code)
+			}
+			// 不同的请求参数使用不同的ETag
+			if w = doRequestWithHeader(engine, target+"?backDay=1", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+				t.Errorf("%s: got status %d with other query, want 200", target, w.Code)
+			}
+		}
+	})
+
+	t.Run("conditional get across days", func(t *testing.T) {
+		// 模拟数据在前一天更新
+		oldChannelsUpdatedAt, oldEPGUpdatedAt := channelsUpdatedAt.Load(), epgUpdatedAt.Load()
+		defer func() {
+			channelsUpdatedAt.Store(oldChannelsUpdatedAt)
+			epgUpdatedAt.Store(oldEPGUpdatedAt)
+		}()
+		// json格式按本地时区，xmltv格式按节目单所在的时区计算当天零点
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		now = now.In(epgLocation)
+		epgToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, epgLocation)
+		yesterday := today.Add(-time.Hour)
+		if epgToday.Before(today) {
+			yesterday = epgToday.Add(-time.Hour)
+		}
+		channelsUpdatedAt.Store(yesterday.UnixNano())
+		epgUpdatedAt.Store(yesterday.UnixNano())
+
+		// 内容与当前日期有关的请求，更新时间不早于当天零点
+		tests := []struct {
+			target string
+			want   time.Time
+		}{
+			{target: "/epg/json?ch=CCTV-1高清", want: today},
+			{target: "/epg/json?ch=CCTV-1高清&date=" + today.Format("2006-01-02"), want: yesterday},
+			{target: "/epg/xml?backDay=1", want: epgToday},
+			{target: "/epg/xml.gz?backDay=1", want: epgToday},
+			{target: "/epg/xml", want: yesterday},
+		}
+		for _, tt := range tests {
+			w := doRequest(engine, tt.target)
+			if got := w.Header().Get("Last-Modified"); got != tt.want.UTC().Format(http.TimeFormat) {
+				t.Errorf("%s: got Last-Modified %q, want %v", tt.target, got, tt.want)
+			}
+			ifModifiedSince := yesterday.UTC().Format(http.TimeFormat)
+			wantCode := http.StatusOK
+			if tt.want.Equal(yesterday) {
+				wantCode = http.StatusNotModified
+			}
+			if w = doRequestWithHeader(engine, tt.target, map[string]string{"If-Modified-Since": ifModifiedSince}); w.Code != wantCode {
+				t.Errorf("%s: got status %d with If-Modified-Since, want %d", tt.target, w.Code, wantCode)
+			}
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		for _, target := range []string{"/channel/m3u", "/epg/json?ch=CCTV-1高清", "/epg/xml"} {
+			plain := doRequest(engine, target)
+			w := doRequestWithHeader(engine, target, map[string]string{"Accept-Encoding": "gzip, deflate"})
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("%s: response is not gzipped: %v", target, w.Header())
+			}
+			gzipReader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: failed to read gzip response: %v", target, err)
+			}
+			content, _ := io.ReadAll(gzipReader)
+			if string(content) != plain.Body.String() {
+				t.Errorf("%s: gzipped content differs from the plain one", target)
+			}
+		}
+	})
+
+	t.Run("catchup", func(t *testing.T) {
+		w := doRequest(engine, "/catchup/1002?csFormat=0&playseek=20241122100000-20241122110000")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+
+		// 播放列表中的地址均被改写为本服务的代理地址，不暴露运营商的地址
+		proxyURLs := func(playlist string) []string {
+			var urls []string
+			for _, line := range strings.Split(playlist, "\n") {
+				if _, uri, ok := strings.Cut(line, `URI="`); ok {
+					line, _, _ = strings.Cut(uri, `"`)
+				}
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				if !strings.HasPrefix(line, "/catchup/1002/proxy?") {
+					t.Errorf("got unproxied uri %s in playlist: %s", line, playlist)
+				}
+				urls = append(urls, line)
+			}
+			return urls
+		}
+		if strings.Contains(w.Body.String(), server.Host()) {
+			t.Errorf("upstream host found in playlist: %s", w.Body.String())
+		}
+
+		// 多码率的主播放列表中的子播放列表
+		variants := proxyURLs(w.Body.String())
+		if len(variants) != 1 {
+			t.Fatalf("got %d variants, want 1: %s", len(variants), w.Body.String())
+		}
+		if w = doRequest(engine, variants[0]); w.Code != http.StatusOK {
+			t.Fatalf("got status %d for variant playlist, want 200", w.Code)
+		}
+
+		// 媒体播放列表中的密钥和分片
+		uris := proxyURLs(w.Body.String())
+		if len(uris) != 3 {
+			t.Fatalf("got %d uris, want 3: %s", len(uris), w.Body.String())
+		}
+		for i, want := range []string{
+			"key_20241122100000-20241122110000.key",
+			"segment_20241122100000-20241122110000_001.ts",
+			"segment_20241122100000-20241122110000_002.ts",
+		} {
+			// 代理请求携带会话Cookie
+			w = doRequest(engine, uris[i])
+			if w.Code != http.StatusOK || w.Body.String() != want {
+				t.Errorf("got status %d and body %q for %s, want 200 and %q", w.Code, w.Body.String(), uris[i], want)
+			}
+		}
+
+		// 未经签名的地址无法代理
+		forged := "/catchup/1002/proxy?u=" + url.QueryEscape(server.URL+"/PLTV/forged.ts") + "&s=invalid"
+		if w = doRequest(engine, forged); w.Code != http.StatusForbidden {
+			t.Errorf("got status %d for forged uri, want 403", w.Code)
+		}
+
+		// 自定义的回看参数
+		if w = doRequest(engine, "/catchup/1002?csFormat=2&starttime=20241122020000&endtime=20241122030000"); w.Code != http.StatusOK {
+			t.Errorf("got status %d for custom catchup source, want 200", w.Code)
+		}
+
+		if w = doRequest(engine, "/catchup/1003?csFormat=0&playseek=20241122100000-20241122110000"); w.Code != http.StatusFound {
+			t.Errorf("got status %d for rtsp catchup, want 302", w.Code)
+		}
+		if w = doRequest(engine, "/catchup/9999?csFormat=0&playseek=20241122100000-20241122110000"); w.Code != http.StatusNotFound {
+			t.Errorf("got status %d for unknown channel, want 404", w.Code)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		w := doRequest(engine, "/metrics")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", w.Code)
+		}
+		body := w.Body.String()
+		// 只在启动时认证了一次
+		if got := metricValue(t, authSeries) - authTotal; got != 1 {
+			t.Errorf("got %v successful auths, want 1", got)
+		}
+		for _, want := range []string{
+			`iptv_refresh_total{stage="channel_list",strategy="",result="success"}`,
+			`iptv_refresh_total{stage="epg",strategy="liveplay_30",result="success"}`,
+			`iptv_upstream_requests_total{endpoint="/EPG/jsp/getchannellistHWCTC.jsp",code="200"}`,
+			`iptv_http_requests_total{path="/channel/m3u",code="200"}`,
+			`iptv_http_requests_total{path="/channel/m3u",code="304"}`,
+			"iptv_channels_cache_age_seconds ",
+			"iptv_epg_cache_age_seconds ",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("metric %s not found in: %s", want, body)
+			}
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		if w := doRequest(engine, "/healthz"); w.Code != http.StatusOK {
+			t.Errorf("got status %d for healthz, want 200", w.Code)
+		}
+
+		w := doRequest(engine, "/status")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+		}
+		var status ServiceStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("failed to parse status: %v", err)
+		}
+		if !status.Ready || status.Channels.Count == 0 || status.Channels.LastSuccessAt == nil ||
+			status.EPG.Count == 0 || status.EPG.FirstDate == "" || status.EPG.LastDate < status.EPG.FirstDate {
+			t.Errorf("unexpected status: %s", w.Body.String())
+		}
+		if status.Client == nil || status.Client.ChannelProgramAPI != "liveplay_30" || status.Client.Host != server.Host() {
+			t.Errorf("unexpected client status: %s", w.Body.String())
+		}
+
+		// 频道列表超过最大缓存时长时返回503
+		defer func(age time.Duration) { maxChannelAge = age }(maxChannelAge)
+		maxChannelAge = time.Nanosecond
+		if w = doRequest(engine, "/status"); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d for stale channels, want 503", w.Code)
+		}
+	})
+
+	t.Run("admin refresh", func(t *testing.T) {
+		doPost := func(target, token string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, target, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		if w := doPost("/admin/refresh", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d without token, want 401", w.Code)
+		}
+		if w := doPost("/admin/refresh", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d with wrong token, want 401", w.Code)
+		}
+		if w := doPost("/admin/refresh?target=foo", "secret"); w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d for invalid target, want 400", w.Code)
+		}
+
+		// 使用可控的客户端使任务保持执行状态，直至release被关闭
+		client := &blockingClient{Client: refresher.iptvClient, started: make(chan struct{}), release: make(chan struct{})}
+		refresher.iptvClient = client
+		defer func() {
+			refresher.iptvClient = client.Client
+		}()
+
+		w := doPost("/admin/refresh?target=epg", "secret")
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("got status %d, want 202: %s", w.Code, w.Body.String())
+		}
+		var job RefreshJob
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil || job.ID == "" {
+			t.Fatalf("unexpected job: %s", w.Body.String())
+		}
+		select {
+		case <-client.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the refresh job is not started")
+		}
+
+		// 任务执行期间再次触发时，复用包含所请求数据的任务，否则返回409
+		for _, tt := range []struct {
+			target string
+			want   int
+		}{
+			{target: RefreshTargetEPG, want: http.StatusOK},
+			{target: RefreshTargetEPGToday, want: http.StatusOK},
+			{target: RefreshTargetChannels, want: http.StatusConflict},
+			{target: RefreshTargetAll, want: http.StatusConflict},
+		} {
+			w = doPost("/admin/refresh?target="+tt.target, "secret")
+			var running RefreshJob
+			_ = json.Unmarshal(w.Body.Bytes(), &running)
+			if w.Code != tt.want || running.ID != job.ID || running.Status != RefreshStatusRunning {
+				t.Errorf("%s: got status %d and job %+v, want %d and running job %s", tt.target, w.Code, running, tt.want, job.ID)
+			}
+			if location := w.Header().Get("Location"); location != "/admin/refresh/"+job.ID {
+				t.Errorf("%s: got Location %q", tt.target, location)
+			}
+		}
+		close(client.release)
+
+		// 轮询任务的进度直至结束
+		deadline := time.Now().Add(5 * time.Second)
+		for job.Status == RefreshStatusRunning && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			w = doRequestWithHeader(engine, "/admin/refresh/"+job.ID, map[string]string{"Authorization": "Bearer secret"})
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d when polling the job, want 200", w.Code)
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &job)
+		}
+		if job.Status != RefreshStatusSucceeded || job.FinishedAt == nil {
+			t.Errorf("unexpected job: %+v", job)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		for _, name := range []string{channelsCacheFileName, epgCacheFileName} {
+			if _, err := os.Stat(filepath.Join(dataDir, name)); err != nil {
+				t.Errorf("cache file %s not found: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("schedule", func(t *testing.T) {
+		// 未配置频道列表的执行计划时按interval刷新
+		if maxChannelAge != 2*time.Hour {
+			t.Errorf("got maxChannelAge %s, want 2h", maxChannelAge)
+		}
+
+		var schedules []cron.Schedule
+		for _, spec := range []string{"30 4 * * *", "0 * * * *"} {
+			schedule, err := cron.Parse(spec)
+			if err != nil {
+				t.Fatalf("cron.Parse(%q) error = %v", spec, err)
+			}
+			schedules = append(schedules, schedule)
+		}
+		loc := time.FixedZone("UTC+8", 8*60*60)
+		now := time.Date(2024, 11, 22, 4, 10, 0, 0, loc)
+		if next := nextScheduleTime(schedules, now); !next.Equal(time.Date(2024, 11, 22, 4, 30, 0, 0, loc)) {
+			t.Errorf("got next time %s, want 04:30", next)
+		}
+		if period := schedulePeriod(schedules, now); period != 30*time.Minute {
+			t.Errorf("got period %s, want 30m", period)
+		}
+
+		// 等待重试期间ctx被取消时立即返回
+		defer func(retries int, backoff time.Duration) {
+			refreshRetries, refreshBackoff = retries, backoff
+		}(refreshRetries, refreshBackoff)
+		refreshRetries, refreshBackoff = 3, time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		calls := 0
+		start := time.Now()
+		err := retryWithBackoff(ctx, "test", func() error {
+			calls++
+			return errors.New("failed")
+		})
+		if err == nil || calls != 1 || time.Since(start) > time.Second {
+			t.Errorf("got err %v after %d calls in %s, want an error after 1 call", err, calls, time.Since(start))
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		before := doRequest(engine, "/channel/m3u")
+		etag := before.Header().Get("ETag")
+
+		reloadConf := strings.Replace(testConfig, "platform: hwctc", `udpxy: http://10.0.0.1:4022
+chGroupRules:
+  - name: 卫视频道
+    rules:
+      - '卫视$'
+platform: hwctc`, 1)
+		reloadConf = strings.Replace(reloadConf, "0: 'playseek=", "0: 'reload=1&playseek=", 1)
+		// 放宽频道的过滤规则
+		reloadConf = strings.Replace(reloadConf, "(画中画|", "(", 1)
+		var conf config.Config
+		if err := yaml.Unmarshal([]byte(fmt.Sprintf(reloadConf, server.Host())), &conf); err != nil {
+			t.Fatalf("failed to parse config: %v", err)
+		}
+		if err := conf.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		ReloadConfig(&conf)
+
+		// 缓存的频道立即使用新的分组规则，回看参数及udpxy地址同时生效
+		w := doRequestWithHeader(engine, "/channel/m3u", map[string]string{"If-None-Match": etag})
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d after reload, want 200", w.Code)
+		}
+		body := w.Body.String()
+		for _, want := range []string{`group-title="卫视频道",湖南卫视`, "http://10.0.0.1:4022/", "reload=1&playseek=", ",画中画1\n"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s not found in m3u content after reload: %s", want, body)
+			}
+		}
+
+		// 恢复原有的过滤规则后再次过滤掉
+		var original config.Config
+		if err := yaml.Unmarshal([]byte(fmt.Sprintf(testConfig, server.Host())), &original); err != nil {
+			t.Fatalf("failed to parse config: %v", err)
+		}
+		if err := original.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		ReloadConfig(&original)
+		if body = doRequest(engine, "/channel/m3u").Body.String(); strings.Contains(body, "画中画") {
+			t.Errorf("excluded channel found in m3u content after reload: %s", body)
+		}
+		ReloadConfig(&conf)
+
+		// 拼写错误的配置项不会被当作平台配置而静默忽略
+		var typo config.Config
+		if err := yaml.Unmarshal([]byte(fmt.Sprintf(strings.Replace(testConfig, "epg:", "epgs:", 1), server.Host())), &typo); err != nil {
+			t.Fatalf("failed to parse config: %v", err)
+		}
+		if err := typo.Validate(); err == nil || !strings.Contains(err.Error(), "epgs") {
+			t.Errorf("Validate() error = %v, want an error for the unknown key epgs", err)
+		}
+
+		// 有误的规则不能通过严格校验
+		invalid := config.Config{OptionChGroupRulesList: []config.OptionChannelGroupRules{{Name: "x", Rules: []string{"("}}}}
+		if err := invalid.CheckRules(); err == nil {
+			t.Error("CheckRules() error = nil, want an error for the invalid rule")
+		}
+	})
+
+	if logins := server.Logins(); logins != 1 {
+		t.Errorf("got %d logins, want 1", logins)
+	}
+}
+
+// blockingClient 获取节目单时等待release被关闭，用于控制刷新任务的执行时长
+type blockingClient struct {
+	iptv.Client
+	started chan struct{} // 开始获取节目单时关闭
+	release chan struct{}
+}
+
+func (c *blockingClient) GetAllChannelProgramList(ctx context.Context, channels []iptv.Channel) ([]iptv.ChannelProgramList, error) {
+	close(c.started)
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.Client.GetAllChannelProgramList(ctx, channels)
+}
+
+func TestEngineWithCachedData(t *testing.T) {
+	_, server, dataDir, stop := newTestEngine(t)
+	stop()
+	server.Close()
+
+	// 中间件不可用时，使用缓存的数据启动
+	var conf config.Config
+	if err := yaml.Unmarshal([]byte(fmt.Sprintf(testConfig, server.Host())), &conf); err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	engine, _ := startTestEngine(t, &conf, dataDir)
+	w := doRequest(engine, "/channel/txt")
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusOK || !strings.Contains(string(body), "CCTV-1高清") {
+		t.Errorf("unexpected txt response: %d %s", w.Code, body)
+	}
+}
+
+func TestXmlEPGCacheEviction(t *testing.T) {
+	var cache xmlEPGCache
+	epg, channels := &[]iptv.ChannelProgramList{}, &[]iptv.Channel{}
+
+	// 不同Host生成的内容分别缓存，超出数量上限时淘汰最早生成的内容
+	keys := make([]xmlEPGCacheKey, maxXmlEPGCacheEntries+1)
+	for i := range keys {
+		keys[i] = xmlEPGCacheKey{backDay: 1, logoBaseUrl: fmt.Sprintf("http://host%d/logo", i)}
+		if _, ok := cache.get(keys[i], epg, channels); ok {
+			t.Fatalf("unexpected cached content for %v", keys[i])
+		}
+		cache.put(keys[i], epg, channels, []byte{byte(i)})
+	}
+	if len(cache.entries) != maxXmlEPGCacheEntries {
+		t.Fatalf("got %d cached entries, want %d", len(cache.entries), maxXmlEPGCacheEntries)
+	}
+	if _, ok := cache.get(keys[0], epg, channels); ok {
+		t.Errorf("the oldest entry is not evicted")
+	}
+	if data, ok := cache.get(keys[len(keys)-1], epg, channels); !ok || data[0] != byte(len(keys)-1) {
+		t.Errorf("the newest entry is not cached")
+	}
+
+	// 节目单更新后缓存失效，生成期间节目单已更新的内容不缓存
+	newEPG := &[]iptv.ChannelProgramList{}
+	if _, ok := cache.get(keys[1], newEPG, channels); ok {
+		t.Errorf("cache is not invalidated after the EPG is updated")
+	}
+	cache.put(keys[1], epg, channels, []byte{1})
+	if _, ok := cache.get(keys[1], newEPG, channels); ok {
+		t.Errorf("stale content is cached")
+	}
+}
+
+func TestXmlEPGCacheDate(t *testing.T) {
+	defer func() { xmlEPGCacheData = xmlEPGCache{} }()
+	xmlEPGCacheData = xmlEPGCache{}
+
+	// 指定backDay时，跨天后不再使用前一天生成的内容
+	const logoBaseUrl = "http://host/logo"
+	epg, channels := epgPtr.Load(), channelsPtr.Load()
+	now := time.Now().In(epgLocation)
+	xmlEPGCacheData.get(xmlEPGCacheKey{}, epg, channels)
+	xmlEPGCacheData.put(xmlEPGCacheKey{backDay: 1, date: now.AddDate(0, 0, -1).Format("2006-01-02"), logoBaseUrl: logoBaseUrl}, epg, channels, []byte("yesterday"))
+	xmlEPGCacheData.put(xmlEPGCacheKey{backDay: 2, date: now.Format("2006-01-02"), logoBaseUrl: logoBaseUrl}, epg, channels, []byte("today"))
+
+	var buf bytes.Buffer
+	if err := writeGzipXmlEPG(&buf, 1, logoBaseUrl); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() == "yesterday" {
+		t.Errorf("got the content generated yesterday")
+	}
+	buf.Reset()
+	if err := writeGzipXmlEPG(&buf, 2, logoBaseUrl); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "today" {
+		t.Errorf("got %q, want the content cached today", buf.String())
+	}
+}
+
+func TestSelectMergedProgramLists(t *testing.T) {
+	date := time.Date(2024, 11, 22, 0, 0, 0, 0, time.Local)
+	dateProgramList := []iptv.DateProgram{{Date: date, ProgramList: []iptv.Program{{ProgramName: "新闻联播"}}}}
+	rawChannels := []iptv.Channel{
+		{ChannelID: "1", ChannelName: "CCTV-1高清"},
+		{ChannelID: "2", ChannelName: "CCTV-1"},
+		{ChannelID: "3", ChannelName: "CCTV-2高清"},
+		{ChannelID: "4", ChannelName: "CCTV-2"},
+		{ChannelID: "5", ChannelName: "CCTV-3"},
+	}
+	channels := []iptv.Channel{
+		{ChannelID: "1", ChannelName: "CCTV-1", MergedChannelIDs: []string{"2"}},
+		{ChannelID: "3", ChannelName: "CCTV-2", MergedChannelIDs: []string{"4"}},
+		{ChannelID: "5", ChannelName: "CCTV-3"},
+	}
+
+	// 合并掉的其他清晰度版本同样获取节目单
+	var ids []string
+	for _, channel := range appendMergedChannels(channels, rawChannels) {
+		ids = append(ids, channel.ChannelID)
+	}
+	if want := []string{"1", "3", "5", "2", "4"}; !slices.Equal(ids, want) {
+		t.Errorf("got channels %v, want %v", ids, want)
+	}
+	if len(channels) != 3 {
+		t.Errorf("channels are modified: %v", channels)
+	}
+
+	// 保留的版本有节目单时使用自身的节目单，没有时使用其他版本的节目单
+	chProgLists := []iptv.ChannelProgramList{
+		{ChannelId: "1", ChannelName: "CCTV-1", DateProgramList: dateProgramList},
+		{ChannelId: "2", ChannelName: "CCTV-1", DateProgramList: []iptv.DateProgram{{Date: date}}},
+		{ChannelId: "3", ChannelName: "CCTV-2"},
+		{ChannelId: "4", ChannelName: "CCTV-2", DateProgramList: dateProgramList},
+	}
+	got := selectMergedProgramLists(channels, chProgLists)
+	if len(got) != 2 {
+		t.Fatalf("got %d program lists, want 2: %+v", len(got), got)
+	}
+	for i, channel := range channels[:2] {
+		if got[i].ChannelId != channel.ChannelID || got[i].ChannelName != channel.ChannelName || !reflect.DeepEqual(got[i].DateProgramList, dateProgramList) {
+			t.Errorf("got program list %+v for channel %s", got[i], channel.ChannelID)
+		}
+	}
+}
@@ -2,39 +2,103 @@ package router
 
 import (
 	"context"
-	"iptv/internal/app/iptv"
+	"iptv/internal/pkg/cron"
+	"math/rand/v2"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-const waitSeconds = 30
+// 刷新失败时的重试配置
+var (
+	// 最大重试次数，为负数时不重试
+	refreshRetries int
+	// 首次重试前的等待时间，之后每次翻倍
+	refreshBackoff time.Duration
+	// 重试前等待时间的上限
+	refreshMaxBackoff time.Duration
+)
+
+// Schedule 按执行计划定时刷新指定的数据，与其他刷新任务不会重叠执行。jitter为每次随机延迟的最大时长，避免大量用户同时请求运营商的服务器
+func Schedule(ctx context.Context, target string, schedules []cron.Schedule, jitter time.Duration) {
+	if len(schedules) == 0 {
+		return
+	}
 
-// Schedule 定时调度更新缓存数据
-func Schedule(ctx context.Context, iptvClient iptv.Client, duration time.Duration) {
-	// 创建定时任务
-	ticker := time.NewTicker(duration)
+	backgroundTasks.Add(1)
 	go func() {
+		defer backgroundTasks.Done()
 		for {
+			next := nextScheduleTime(schedules, time.Now().In(epgLocation))
+			if next.IsZero() {
+				logger.Warn("There is no next execution time, stop the scheduling task.", zap.String("target", target))
+				return
+			}
+
+			delay := time.Until(next)
+			if jitter > 0 {
+				delay += rand.N(jitter)
+			}
+			timer := time.NewTimer(delay)
 			select {
 			case <-ctx.Done():
-				logger.Info("The scheduling task has been stopped.")
+				timer.Stop()
+				logger.Info("The scheduling task has been stopped.", zap.String("target", target))
 				return
-			case <-ticker.C:
-				logger.Info("Start executing the scheduling task.")
-
-				// 更新频道列表数据
-				if err := updateChannelsWithRetry(ctx, iptvClient, 3); err != nil {
-					logger.Error("Failed to update channel list.", zap.Error(err))
-				}
-
-				// 更新节目单数据
-				if err := updateEPG(ctx, iptvClient); err != nil {
-					logger.Error("Failed to update EPG.", zap.Error(err))
-				}
+			case <-timer.C:
+			}
 
-				logger.Info("The scheduling task has been completed.")
+			// 已有任务在执行时等待其结束，避免漏掉本次刷新
+			logger.Info("Start executing the scheduling task.", zap.String("target", target))
+			if _, err := refresher.startWhenIdle(ctx, target, "schedule"); err != nil {
+				logger.Info("The scheduling task has been stopped.", zap.String("target", target))
+				return
 			}
 		}
 	}()
 }
+
+// nextScheduleTime 获取多个执行计划中最早的下一次执行时间
+func nextScheduleTime(schedules []cron.Schedule, now time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range schedules {
+		if t := schedule.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// schedulePeriod 估算执行计划的周期，即接下来两次执行之间的间隔
+func schedulePeriod(schedules []cron.Schedule, now time.Time) time.Duration {
+	first := nextScheduleTime(schedules, now)
+	if first.IsZero() {
+		return 0
+	}
+	second := nextScheduleTime(schedules, first)
+	if second.IsZero() {
+		return 0
+	}
+	return second.Sub(first)
+}
+
+// retryWithBackoff 执行fn，失败时按指数退避进行重试，等待期间ctx被取消时立即返回
+func retryWithBackoff(ctx context.Context, name string, fn func() error) error {
+	backoff := refreshBackoff
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= refreshRetries || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Sugar().Errorf("Failed to update %s, will try again after waiting %s. Error: %v, number of retries: %d.", name, backoff, err, i+1)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, refreshMaxBackoff)
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"iptv/internal/app/iptv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// 频道列表的最大缓存时长，超过时服务视为未就绪
+	maxChannelAge time.Duration
+	// 提供IPTV客户端运行状态的可选接口
+	statusProvider iptv.StatusProvider
+
+	channelsRefresh refreshStatus
+	epgRefresh      refreshStatus
+)
+
+// refreshStatus 记录最近一次成功及失败的刷新
+type refreshStatus struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+// record 记录刷新的结果
+func (s *refreshStatus) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastFailure = time.Now()
+		s.lastError = err.Error()
+	} else {
+		s.lastSuccess = time.Now()
+	}
+}
+
+// fill 将刷新结果填充到数据状态中
+func (s *refreshStatus) fill(status *CacheStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status.LastSuccessAt = timePtr(s.lastSuccess)
+	status.LastFailureAt = timePtr(s.lastFailure)
+	status.LastError = s.lastError
+}
+
+// CacheStatus 缓存数据的状态
+type CacheStatus struct {
+	Count         int        `json:"count"`                   // 频道数量
+	UpdatedAt     *time.Time `json:"updatedAt"`               // 缓存数据的更新时间，包括从磁盘加载的缓存
+	LastSuccessAt *time.Time `json:"lastSuccessAt"`           // 本次运行中最近一次成功刷新的时间
+	LastFailureAt *time.Time `json:"lastFailureAt"`           // 本次运行中最近一次刷新失败的时间
+	LastError     string     `json:"lastError,omitempty"`     // 最近一次刷新失败的错误信息
+	FirstDate     string     `json:"firstDate,omitempty"`     // 节目单的最早日期
+	LastDate      string     `json:"lastDate,omitempty"`      // 节目单的最晚日期
+	MaxAgeSeconds float64    `json:"maxAgeSeconds,omitempty"` // 最大缓存时长（秒），超过时服务视为未就绪
+}
+
+// ServiceStatus 服务的运行状态
+type ServiceStatus struct {
+	Ready    bool               `json:"ready"`            // 频道列表是否可用且未超过最大缓存时长
+	Channels CacheStatus        `json:"channels"`         // 频道列表的状态
+	EPG      CacheStatus        `json:"epg"`              // 节目单的状态
+	Client   *iptv.ClientStatus `json:"client,omitempty"` // IPTV客户端的运行状态
+}
+
+// GetHealthz 进程存活检查
+func GetHealthz(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// GetStatus 查询数据的更新状态，频道列表不可用或超过最大缓存时长时返回503
+func GetStatus(c *gin.Context) {
+	status := getServiceStatus(time.Now())
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, status)
+}
+
+// getServiceStatus 获取服务的运行状态
+func getServiceStatus(now time.Time) *ServiceStatus {
+	status := ServiceStatus{
+		Channels: CacheStatus{
+			Count:         len(loadChannels()),
+			UpdatedAt:     timePtr(unixNanoTime(channelsUpdatedAt.Load())),
+			MaxAgeSeconds: maxChannelAge.Seconds(),
+		},
+		EPG: CacheStatus{
+			UpdatedAt: timePtr(unixNanoTime(epgUpdatedAt.Load())),
+		},
+	}
+	channelsRefresh.fill(&status.Channels)
+	epgRefresh.fill(&status.EPG)
+
+	// 节目单的频道数量及日期范围
+	epg := loadEPG()
+	status.EPG.Count = len(epg)
+	var firstDate, lastDate time.Time
+	for _, chProgList := range epg {
+		for _, dateProgList := range chProgList.DateProgramList {
+			if firstDate.IsZero() || dateProgList.Date.Before(firstDate) {
+				firstDate = dateProgList.Date
+			}
+			if dateProgList.Date.After(lastDate) {
+				lastDate = dateProgList.Date
+			}
+		}
+	}
+	if !firstDate.IsZero() {
+		status.EPG.FirstDate = firstDate.Format(time.DateOnly)
+		status.EPG.LastDate = lastDate.Format(time.DateOnly)
+	}
+
+	if statusProvider != nil {
+		clientStatus := statusProvider.Status()
+		status.Client = &clientStatus
+	}
+
+	// 频道列表可用，且未超过最大缓存时长时视为就绪
+	status.Ready = status.Channels.Count > 0 && status.Channels.UpdatedAt != nil &&
+		(maxChannelAge <= 0 || now.Sub(*status.Channels.UpdatedAt) <= maxChannelAge)
+	return &status
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
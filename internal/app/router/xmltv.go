@@ -0,0 +1,171 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"iptv/internal/app/iptv"
+	"sync"
+	"time"
+)
+
+// maxXmlEPGCacheEntries 缓存的xmltv内容的最大数量，超出时淘汰最早生成的内容
+const maxXmlEPGCacheEntries = 16
+
+// xmlEPGCacheKey 缓存的xmltv内容的key，台标地址与请求的Host有关，指定backDay时保留的日期与当天的日期有关
+type xmlEPGCacheKey struct {
+	backDay     int
+	date        string
+	logoBaseUrl string
+}
+
+// xmlEPGCache 缓存生成的xmltv内容（gzip压缩），节目单或频道列表更新后失效
+type xmlEPGCache struct {
+	mu       sync.Mutex
+	epg      *[]iptv.ChannelProgramList
+	channels *[]iptv.Channel
+	entries  map[xmlEPGCacheKey][]byte
+	keys     []xmlEPGCacheKey // 按生成的先后顺序排列
+}
+
+var xmlEPGCacheData xmlEPGCache
+
+// get 获取缓存的xmltv内容，节目单或频道列表已更新时清空缓存
+func (c *xmlEPGCache) get(key xmlEPGCacheKey, epg *[]iptv.ChannelProgramList, channels *[]iptv.Channel) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.epg != epg || c.channels != channels || c.entries == nil {
+		c.epg = epg
+		c.channels = channels
+		c.entries = make(map[xmlEPGCacheKey][]byte)
+		c.keys = nil
+	}
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+// put 缓存生成的xmltv内容，生成期间节目单或频道列表已更新时丢弃
+func (c *xmlEPGCache) put(key xmlEPGCacheKey, epg *[]iptv.ChannelProgramList, channels *[]iptv.Channel, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.epg != epg || c.channels != channels || c.entries == nil {
+		return
+	}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.keys) >= maxXmlEPGCacheEntries {
+		delete(c.entries, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.entries[key] = data
+	c.keys = append(c.keys, key)
+}
+
+// writeGzipXmlEPG 将gzip压缩后的xmltv内容写入w，缓存失效时边生成边写入，同时缓存生成的内容
+func writeGzipXmlEPG(w io.Writer, backDay int, logoBaseUrl string) error {
+	key := xmlEPGCacheKey{backDay: backDay, logoBaseUrl: logoBaseUrl}
+	if backDay > 0 {
+		key.date = time.Now().In(epgLocation).Format("2006-01-02")
+	}
+	epg, channels := epgPtr.Load(), channelsPtr.Load()
+	if data, ok := xmlEPGCacheData.get(key, epg, channels); ok {
+		_, err := w.Write(data)
+		return err
+	}
+
+	// 生成期间不持有锁，避免慢速的客户端阻塞其他请求
+	var chProgLists []iptv.ChannelProgramList
+	if epg != nil {
+		chProgLists = *epg
+	}
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(io.MultiWriter(&buf, w))
+	if err := writeXmlEPG(gzipWriter, chProgLists, backDay, logoBaseUrl); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	xmlEPGCacheData.put(key, epg, channels, buf.Bytes())
+	return nil
+}
+
+// writeXmlEPG 将频道节目单逐个编码为xmltv格式并写入w，避免在内存中构建完整的文档
+func writeXmlEPG(w io.Writer, chProgLists []iptv.ChannelProgramList, backDay int, logoBaseUrl string) error {
+	backTime := time.Now().In(epgLocation).AddDate(0, 0, -backDay)
+	backTime = time.Date(backTime.Year(), backTime.Month(), backTime.Day(), 0, 0, 0, 0, backTime.Location())
+
+	// 写入xml头
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	tvStart := xml.StartElement{
+		Name: xml.Name{Local: "tv"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "generator-info-name"}, Value: xmltvGenInfoName},
+			{Name: xml.Name{Local: "generator-info-url"}, Value: xmltvGenInfoUrl},
+		},
+	}
+	if err := encoder.EncodeToken(tvStart); err != nil {
+		return err
+	}
+
+	// 频道号、台标等信息从频道列表中获取
+	channelMap := make(map[string]*iptv.Channel)
+	allChannels := loadChannels()
+	for i := range allChannels {
+		channelMap[allChannels[i].ChannelID] = &allChannels[i]
+	}
+
+	// 先写入所有频道的信息
+	channelStart := xml.StartElement{Name: xml.Name{Local: "channel"}}
+	for _, chProgList := range chProgLists {
+		xmlChannel := XmlEPGChannel{
+			Id: chProgList.ChannelId,
+			DisplayName: &XmlEPGDisplay{
+				Lang:  epgLang,
+				Value: chProgList.ChannelName,
+			},
+		}
+		if channel, ok := channelMap[chProgList.ChannelId]; ok {
+			xmlChannel.LCN = channel.UserChannelID
+			if logoUrl := iptv.GetChannelLogoURL(logoBaseUrl, channel.LogoName); logoUrl != "" {
+				xmlChannel.Icon = &XmlEPGIcon{Src: logoUrl}
+			}
+		}
+		if err := encoder.EncodeElement(&xmlChannel, channelStart); err != nil {
+			return err
+		}
+	}
+
+	// 再写入所有节目的信息
+	programmeStart := xml.StartElement{Name: xml.Name{Local: "programme"}}
+	for _, chProgList := range chProgLists {
+		for _, dateProgList := range chProgList.DateProgramList {
+			if len(dateProgList.ProgramList) == 0 ||
+				(backDay > 0 && !backTime.Before(dateProgList.Date)) {
+				continue
+			}
+			for _, program := range dateProgList.ProgramList {
+				programme := getXmlEPGProgramme(chProgList.ChannelId, &program)
+				if err := encoder.EncodeElement(&programme, programmeStart); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := encoder.EncodeToken(tvStart.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
@@ -0,0 +1,234 @@
+// Package capture 录制和回放与运营商服务器之间的HTTP交互，便于离线复现频道列表、节目单解析等问题。
+package capture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 敏感信息被替换后的内容
+	redacted = "REDACTED"
+	// 录制文件的扩展名
+	fileExt = ".json"
+)
+
+// 响应页面中以 "name" value="xxx" 形式出现的字段
+var htmlValueRegex = regexp.MustCompile(`"([A-Za-z]+)"\s+value="([^"]*)"`)
+
+// Exchange 一次HTTP请求和响应的录制内容
+type Exchange struct {
+	Request  *Message `json:"request"`
+	Response *Message `json:"response"`
+}
+
+// Message HTTP请求或响应
+type Message struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"bodyBase64,omitempty"` // 非文本内容使用base64编码
+}
+
+// setBody 设置消息体，非UTF-8文本时使用base64编码
+func (m *Message) setBody(body []byte) {
+	if utf8.Valid(body) {
+		m.Body = string(body)
+		m.BodyBase64 = false
+	} else {
+		m.Body = base64.StdEncoding.EncodeToString(body)
+		m.BodyBase64 = true
+	}
+}
+
+// body 获取原始的消息体
+func (m *Message) body() ([]byte, error) {
+	if m.BodyBase64 {
+		return base64.StdEncoding.DecodeString(m.Body)
+	}
+	return []byte(m.Body), nil
+}
+
+// redactor 对请求参数和响应内容中的敏感字段进行脱敏
+type redactor struct {
+	fields map[string]struct{} // 需要脱敏的字段名称（小写）
+}
+
+func newRedactor(fields []string) *redactor {
+	r := &redactor{
+		fields: make(map[string]struct{}, len(fields)),
+	}
+	for _, field := range fields {
+		r.fields[strings.ToLower(field)] = struct{}{}
+	}
+	return r
+}
+
+func (r *redactor) isSensitive(name string) bool {
+	_, ok := r.fields[strings.ToLower(name)]
+	return ok
+}
+
+// redactValues 将url.Values中敏感字段的值替换掉，返回被替换的原始值
+func (r *redactor) redactValues(values url.Values) []string {
+	var secrets []string
+	for k, vs := range values {
+		if !r.isSensitive(k) {
+			continue
+		}
+		for i, v := range vs {
+			if v != "" {
+				secrets = append(secrets, v)
+			}
+			vs[i] = redacted
+		}
+	}
+	return secrets
+}
+
+// redactQuery 对URL查询参数进行脱敏
+func (r *redactor) redactQuery(u *url.URL) (string, []string) {
+	if u.RawQuery == "" {
+		return u.String(), nil
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.String(), nil
+	}
+	secrets := r.redactValues(query)
+	if len(secrets) == 0 {
+		return u.String(), nil
+	}
+
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.String(), secrets
+}
+
+// redactForm 对表单格式的请求体进行脱敏
+func (r *redactor) redactForm(body []byte) ([]byte, []string) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || len(form) == 0 {
+		return body, nil
+	}
+	secrets := r.redactValues(form)
+	if len(secrets) == 0 {
+		return body, nil
+	}
+	return []byte(form.Encode()), secrets
+}
+
+// htmlSecrets 提取响应页面中敏感字段的值，如 "UserToken" value="xxx"
+func (r *redactor) htmlSecrets(body []byte) []string {
+	var secrets []string
+	for _, matches := range htmlValueRegex.FindAllSubmatch(body, -1) {
+		if r.isSensitive(string(matches[1])) && len(matches[2]) > 0 {
+			secrets = append(secrets, string(matches[2]))
+		}
+	}
+	return secrets
+}
+
+// redactCookies 替换Cookie及Set-Cookie头中所有Cookie的值，返回敏感字段（如JSESSIONID）对应的原始值
+func (r *redactor) redactCookies(header http.Header) (http.Header, []string) {
+	var secrets []string
+	redactPair := func(pair string) string {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return pair
+		}
+		if value != "" && r.isSensitive(name) {
+			secrets = append(secrets, strings.Trim(value, `"`))
+		}
+		return name + "=" + redacted
+	}
+
+	result := header.Clone()
+	for i, line := range result["Cookie"] {
+		pairs := strings.Split(line, ";")
+		for j, pair := range pairs {
+			pairs[j] = redactPair(pair)
+		}
+		result["Cookie"][i] = strings.Join(pairs, "; ")
+	}
+	// Set-Cookie中只有第一项为Cookie的值，其余为Path等属性
+	for i, line := range result["Set-Cookie"] {
+		pair, attrs, hasAttrs := strings.Cut(line, ";")
+		result["Set-Cookie"][i] = redactPair(pair)
+		if hasAttrs {
+			result["Set-Cookie"][i] += ";" + attrs
+		}
+	}
+	return result, secrets
+}
+
+// replaceSecrets 替换内容中所有已知的敏感值（包括URL编码后的形式）
+func replaceSecrets(s string, secrets map[string]struct{}) string {
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, redacted)
+		}
+	}
+	return s
+}
+
+// normalizeRequest 生成用于匹配录制内容的请求标识：方法、路径、脱敏后的查询参数和请求体
+func (r *redactor) normalizeRequest(method string, u *url.URL, body []byte, contentType string) string {
+	query, _ := url.ParseQuery(u.RawQuery)
+	r.redactValues(query)
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		body, _ = r.redactForm(body)
+	}
+	return method + " " + u.Path + "?" + query.Encode() + "\n" + string(body)
+}
+
+// readBody 读取并还原请求体
+func readBody(rc io.ReadCloser) ([]byte, io.ReadCloser, error) {
+	if rc == nil || rc == http.NoBody {
+		return nil, rc, nil
+	}
+	defer rc.Close()
+	body, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// readExchanges 读取目录中的所有录制内容，按文件名顺序返回
+func readExchanges(dir string) ([]*Exchange, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := make([]*Exchange, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var exchange Exchange
+		if err = json.Unmarshal(content, &exchange); err != nil {
+			return nil, err
+		}
+		if exchange.Request == nil || exchange.Response == nil {
+			continue
+		}
+		exchanges = append(exchanges, &exchange)
+	}
+	return exchanges, nil
+}
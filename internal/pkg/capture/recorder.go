@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Recorder 录制HTTP请求和响应的RoundTripper，录制内容中的敏感字段会被脱敏
+type Recorder struct {
+	dir      string
+	next     http.RoundTripper
+	redactor *redactor
+
+	mu      sync.Mutex
+	seq     int
+	secrets map[string]struct{} // 已知的敏感值，在所有录制内容中替换
+}
+
+// NewRecorder 创建录制器，录制内容保存至dir目录，sensitiveFields为需要脱敏的请求参数、页面字段及Cookie名称（不区分大小写）。
+// 所有Cookie的值均会被脱敏
+func NewRecorder(dir string, next http.RoundTripper, sensitiveFields []string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// 追加录制时，文件序号接着已有的录制内容
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{
+		dir:      dir,
+		next:     next,
+		redactor: newRedactor(sensitiveFields),
+		seq:      len(files),
+		secrets:  make(map[string]struct{}),
+	}, nil
+}
+
+// RoundTrip 执行请求并录制请求和响应
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, body, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = body
+
+	if err = r.save(req, reqBody, resp, respBody); err != nil {
+		return nil, fmt.Errorf("failed to save the recording: %w", err)
+	}
+	return resp, nil
+}
+
+// save 脱敏后保存录制内容
+func (r *Recorder) save(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte) error {
+	reqURL, urlSecrets := r.redactor.redactQuery(req.URL)
+	reqBody, formSecrets := r.redactor.redactForm(reqBody)
+	reqHeader, reqCookieSecrets := r.redactor.redactCookies(req.Header)
+	respHeader, respCookieSecrets := r.redactor.redactCookies(resp.Header)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 收集敏感值，如认证后页面中返回的UserToken、Cookie中的JSESSIONID
+	for _, secrets := range [][]string{urlSecrets, formSecrets, reqCookieSecrets, respCookieSecrets, r.redactor.htmlSecrets(respBody)} {
+		for _, secret := range secrets {
+			r.secrets[secret] = struct{}{}
+		}
+	}
+
+	exchange := &Exchange{
+		Request: &Message{
+			Method: req.Method,
+			URL:    replaceSecrets(reqURL, r.secrets),
+			Header: r.redactHeader(reqHeader),
+		},
+		Response: &Message{
+			StatusCode: resp.StatusCode,
+			Header:     r.redactHeader(respHeader),
+		},
+	}
+	exchange.Request.setBody([]byte(replaceSecrets(string(reqBody), r.secrets)))
+	exchange.Response.setBody([]byte(replaceSecrets(string(respBody), r.secrets)))
+
+	content, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.seq++
+	return os.WriteFile(filepath.Join(r.dir, fmt.Sprintf("%05d%s", r.seq, fileExt)), content, 0644)
+}
+
+// redactHeader 替换请求头或响应头中的敏感值
+func (r *Recorder) redactHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for k, vs := range header {
+		values := make([]string, len(vs))
+		for i, v := range vs {
+			values[i] = replaceSecrets(v, r.secrets)
+		}
+		result[k] = values
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// Replayer 使用录制内容响应HTTP请求的RoundTripper，不会访问网络
+type Replayer struct {
+	redactor *redactor
+
+	mu     sync.Mutex
+	exact  map[string][]*Exchange // 按方法、路径、参数和请求体匹配
+	byPath map[string][]*Exchange // 按方法和路径匹配
+	next   map[string]int         // 同一匹配条件下已使用的录制内容数量
+}
+
+// NewReplayer 加载dir目录中的录制内容，sensitiveFields需与录制时保持一致
+func NewReplayer(dir string, sensitiveFields []string) (*Replayer, error) {
+	exchanges, err := readExchanges(dir)
+	if err != nil {
+		return nil, err
+	} else if len(exchanges) == 0 {
+		return nil, fmt.Errorf("no recordings found in %s", dir)
+	}
+
+	r := &Replayer{
+		redactor: newRedactor(sensitiveFields),
+		exact:    make(map[string][]*Exchange),
+		byPath:   make(map[string][]*Exchange),
+		next:     make(map[string]int),
+	}
+	for _, exchange := range exchanges {
+		reqURL, err := url.Parse(exchange.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		body, err := exchange.Request.body()
+		if err != nil {
+			return nil, err
+		}
+
+		key := r.redactor.normalizeRequest(exchange.Request.Method, reqURL, body, exchange.Request.Header.Get("Content-Type"))
+		r.exact[key] = append(r.exact[key], exchange)
+		pathKey := exchange.Request.Method + " " + reqURL.Path
+		r.byPath[pathKey] = append(r.byPath[pathKey], exchange)
+	}
+	return r, nil
+}
+
+// RoundTrip 查找匹配的录制内容并返回录制的响应
+// 优先精确匹配请求参数和请求体，找不到时（如参数中含有时间戳）按路径依次返回录制内容
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, _, err := readBody(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	key := r.redactor.normalizeRequest(req.Method, req.URL, reqBody, req.Header.Get("Content-Type"))
+	exchange := r.match(r.exact, key)
+	if exchange == nil {
+		exchange = r.match(r.byPath, req.Method+" "+req.URL.Path)
+	}
+	if exchange == nil {
+		return newResponse(req, http.StatusNotFound, http.Header{}, []byte("no recording found")), nil
+	}
+
+	body, err := exchange.Response.body()
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(req, exchange.Response.StatusCode, exchange.Response.Header.Clone(), body), nil
+}
+
+// match 依次返回匹配条件下的录制内容，全部使用过后从头开始循环
+func (r *Replayer) match(exchanges map[string][]*Exchange, key string) *Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := exchanges[key]
+	if len(list) == 0 {
+		return nil
+	}
+	counterKey := strconv.Itoa(len(list)) + key
+	i := r.next[counterKey]
+	r.next[counterKey] = i + 1
+	return list[i%len(list)]
+}
+
+func newResponse(req *http.Request, statusCode int, header http.Header, body []byte) *http.Response {
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
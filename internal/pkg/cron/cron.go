@@ -0,0 +1,223 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 定时任务的执行计划
+type Schedule interface {
+	// Next 获取晚于t的下一次执行时间，找不到时返回零值
+	Next(t time.Time) time.Time
+}
+
+// 查找下一次执行时间的最大范围，避免如2月30日这类永远无法匹配的表达式陷入死循环
+const maxSearchYears = 5
+
+// field 表达式中各个字段的取值范围
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int // 取值的别名，如月份和星期的英文缩写
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 星期的取值中0和7均表示星期日
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// 预定义的表达式
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse 解析cron表达式，支持标准的5个字段（分 时 日 月 星期）、预定义的表达式（如@daily）以及“@every 时长”。
+// 字段支持*、?、数值、范围（1-5）、步长（*/15、1-30/5）及列表（1,15,30），月份和星期支持英文缩写
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid cron spec %q: the duration must be positive", spec)
+		}
+		return Every(d), nil
+	}
+	if expanded, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron spec %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var s specSchedule
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	// 7与0同为星期日
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = isStar(fields[2])
+	s.dowStar = isStar(fields[4])
+	return &s, nil
+}
+
+// parse 解析单个字段，返回以位表示的取值集合
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepExpr, f.name)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangeExpr, "-"):
+			loExpr, hiExpr, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if lo, err = f.value(loExpr); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(hiExpr); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeExpr, f.name)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rangeExpr); err != nil {
+				return 0, err
+			}
+			// 如5/10表示从5开始每隔10
+			hi = lo
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value 解析单个取值，支持英文缩写
+func (f field) value(expr string) (int, error) {
+	if v, ok := f.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", expr, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+func isStar(expr string) bool {
+	return expr == "*" || expr == "?"
+}
+
+// specSchedule 由cron表达式解析得到的执行计划
+type specSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool // 日和星期字段是否为*，两者均有限制时满足其一即可
+}
+
+// Next 获取晚于t的下一次执行时间，使用t所在的时区。
+// 夏令时开始时不存在的时刻将被跳过，结束时重复出现的时刻只执行一次
+func (s *specSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// 以UTC表示t所在时区的挂钟时间进行查找，避免夏令时切换时time.Date将时间规范化到更早的时刻而陷入死循环。从下一分钟开始查找
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := wall.AddDate(maxSearchYears, 0, 0)
+
+	for wall.Before(limit) {
+		if s.month&(1<<uint(wall.Month())) == 0 {
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(wall) {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(wall.Hour())) == 0 {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minute&(1<<uint(wall.Minute())) == 0 {
+			wall = wall.Add(time.Minute)
+			continue
+		}
+
+		// 转换为t所在时区的时间，挂钟时间不存在或已经过去（重复出现的时刻）时继续查找
+		next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+		if next.After(t) && next.Hour() == wall.Hour() && next.Minute() == wall.Minute() {
+			return next
+		}
+		wall = wall.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// dayMatches 判断日期是否匹配，与常见的cron实现一致：日和星期均有限制时满足其一即可
+func (s *specSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Every 固定间隔执行的计划
+type Every time.Duration
+
+// Next 获取t之后间隔指定时长的时间
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
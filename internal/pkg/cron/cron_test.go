@@ -0,0 +1,141 @@
+package cron_test
+
+import (
+	"iptv/internal/pkg/cron"
+	"testing"
+	"time"
+)
+
+// nextTimes 从start开始依次获取n次执行时间
+func nextTimes(t *testing.T, spec string, start time.Time, n int) []time.Time {
+	t.Helper()
+	s, err := cron.Parse(spec)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", spec, err)
+	}
+	result := make([]time.Time, 0, n)
+	for next := start; len(result) < n; {
+		next = s.Next(next)
+		result = append(result, next)
+		if next.IsZero() {
+			break
+		}
+	}
+	return result
+}
+
+func TestNext(t *testing.T) {
+	// 2024-01-01为星期一
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		start time.Time
+		want  []time.Time
+	}{
+		{name: "every minute", spec: "* * * * *", start: start, want: []time.Time{at(1, 1, 0, 1), at(1, 1, 0, 2)}},
+		{name: "seconds ignored", spec: "* * * * *", start: start.Add(30 * time.Second), want: []time.Time{at(1, 1, 0, 1)}},
+		{name: "step", spec: "*/15 * * * *", start: start, want: []time.Time{at(1, 1, 0, 15), at(1, 1, 0, 30), at(1, 1, 0, 45), at(1, 1, 1, 0)}},
+		{name: "step from value", spec: "5/20 * * * *", start: start, want: []time.Time{at(1, 1, 0, 5), at(1, 1, 0, 25), at(1, 1, 0, 45), at(1, 1, 1, 5)}},
+		{name: "step in range", spec: "0 1-10/4 * * *", start: start, want: []time.Time{at(1, 1, 1, 0), at(1, 1, 5, 0), at(1, 1, 9, 0), at(1, 2, 1, 0)}},
+		{name: "list and range", spec: "0,30 8-9 * * *", start: start, want: []time.Time{at(1, 1, 8, 0), at(1, 1, 8, 30), at(1, 1, 9, 0), at(1, 1, 9, 30), at(1, 2, 8, 0)}},
+		{name: "month name", spec: "0 0 1 mar,JUN *", start: start, want: []time.Time{at(3, 1, 0, 0), at(6, 1, 0, 0)}},
+		{name: "day of month only", spec: "0 0 13 * *", start: start, want: []time.Time{at(1, 13, 0, 0), at(2, 13, 0, 0)}},
+		{name: "day of week only", spec: "0 0 * * 5", start: start, want: []time.Time{at(1, 5, 0, 0), at(1, 12, 0, 0), at(1, 19, 0, 0)}},
+		{name: "question mark", spec: "0 0 ? * fri", start: start, want: []time.Time{at(1, 5, 0, 0), at(1, 12, 0, 0)}},
+		// 日和星期均有限制时满足其一即可：13日或星期五
+		{name: "day of month or week", spec: "0 0 13 * 5", start: start, want: []time.Time{at(1, 5, 0, 0), at(1, 12, 0, 0), at(1, 13, 0, 0), at(1, 19, 0, 0), at(1, 26, 0, 0), at(2, 2, 0, 0)}},
+		{name: "sunday as 0", spec: "0 0 * * 0", start: start, want: []time.Time{at(1, 7, 0, 0), at(1, 14, 0, 0)}},
+		{name: "sunday as 7", spec: "0 0 * * 7", start: start, want: []time.Time{at(1, 7, 0, 0), at(1, 14, 0, 0)}},
+		{name: "weekend range", spec: "0 0 * * 6-7", start: start, want: []time.Time{at(1, 6, 0, 0), at(1, 7, 0, 0), at(1, 13, 0, 0)}},
+		{name: "leap day", spec: "0 0 29 2 *", start: start, want: []time.Time{at(2, 29, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)}},
+		{name: "never", spec: "0 0 30 2 *", start: start, want: []time.Time{{}}},
+		{name: "hourly", spec: "@hourly", start: start, want: []time.Time{at(1, 1, 1, 0), at(1, 1, 2, 0)}},
+		{name: "weekly", spec: "@weekly", start: start, want: []time.Time{at(1, 7, 0, 0)}},
+		{name: "every", spec: "@every 90m", start: start.Add(30 * time.Second), want: []time.Time{start.Add(90*time.Minute + 30*time.Second), start.Add(180*time.Minute + 30*time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextTimes(t, tt.spec, tt.start, len(tt.want))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone is not available: %v", err)
+	}
+	at := func(month time.Month, day, hour, minute int, zone string) time.Time {
+		offset := -5 * 3600
+		if zone == "EDT" {
+			offset = -4 * 3600
+		}
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.FixedZone(zone, offset))
+	}
+
+	// 2024-03-10 02:00夏令时开始，时钟拨快至03:00；2024-11-03 02:00夏令时结束，时钟拨回至01:00
+	tests := []struct {
+		name  string
+		spec  string
+		start time.Time
+		want  []time.Time
+	}{
+		{name: "skipped time", spec: "30 2 * * *", start: time.Date(2024, 3, 10, 0, 0, 0, 0, loc), want: []time.Time{at(3, 11, 2, 30, "EDT"), at(3, 12, 2, 30, "EDT")}},
+		{name: "hourly at spring forward", spec: "0 * * * *", start: time.Date(2024, 3, 10, 0, 30, 0, 0, loc), want: []time.Time{at(3, 10, 1, 0, "EST"), at(3, 10, 3, 0, "EDT"), at(3, 10, 4, 0, "EDT")}},
+		{name: "repeated time", spec: "30 1 * * *", start: time.Date(2024, 11, 3, 0, 0, 0, 0, loc), want: []time.Time{at(11, 3, 1, 30, "EDT"), at(11, 4, 1, 30, "EST")}},
+		{name: "within repeated hour", spec: "30 1 * * *", start: at(11, 3, 1, 10, "EST").In(loc), want: []time.Time{at(11, 4, 1, 30, "EST")}},
+		{name: "hourly at fall back", spec: "0 * * * *", start: time.Date(2024, 11, 3, 0, 30, 0, 0, loc), want: []time.Time{at(11, 3, 1, 0, "EDT"), at(11, 3, 2, 0, "EST"), at(11, 3, 3, 0, "EST")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextTimes(t, tt.spec, tt.start, len(tt.want))
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) || got[i].Location() != loc {
+					t.Errorf("got %v, want %v in %s", got, tt.want, loc)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"61 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"@every",
+		"@every -1m",
+		"@every 1x",
+		"@unknown",
+	} {
+		if _, err := cron.Parse(spec); err == nil {
+			t.Errorf("Parse(%q) error = nil, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelSeparator 拼接标签值作为key时使用的分隔符
+const labelSeparator = "\xff"
+
+// collector 以Prometheus文本格式输出指标
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry 指标的注册表
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// DefaultRegistry 缺省的注册表，/metrics接口输出其中的所有指标
+var DefaultRegistry = &Registry{}
+
+// register 注册指标，指标名称重复时panic
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, registered := range r.collectors {
+		if registered.name() == c.name() {
+			panic(fmt.Sprintf("duplicate metric: %s", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Write 以Prometheus文本格式输出所有指标，按指标名称排序
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	slices.SortFunc(collectors, func(a, b collector) int {
+		return strings.Compare(a.name(), b.name())
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler 输出缺省注册表中所有指标的HTTP处理器
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = DefaultRegistry.Write(w)
+	})
+}
+
+// desc 指标的描述信息
+type desc struct {
+	metricName string
+	help       string
+	labelNames []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w *bufio.Writer, metricType string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, metricType)
+}
+
+// labelKey 将标签值拼接为key，标签值的数量与标签名称不一致时panic
+func (d *desc) labelKey(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+// formatLabels 格式化标签，如：{stage="auth",result="success"}，extra为额外追加的标签
+func (d *desc) formatLabels(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labelNames) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, d.labelNames[i]+`="`+escapeLabelValue(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec 创建带标签的计数器，并注册到缺省的注册表
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := &CounterVec{
+		desc:   desc{metricName: name, help: help, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+	DefaultRegistry.register(v)
+	return v
+}
+
+// Inc 计数加1
+func (v *CounterVec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Add 计数增加delta，delta不能小于0
+func (v *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metric %s: counter cannot decrease", v.metricName))
+	}
+	key := v.labelKey(labelValues)
+	v.mu.Lock()
+	v.values[key] += delta
+	v.mu.Unlock()
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w, "counter")
+	for _, key := range sortedKeys(v.values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.formatLabels(key), formatFloat(v.values[key]))
+	}
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64 // 各个桶（不含+Inf）的计数，非累计值
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec 创建带标签的直方图，并注册到缺省的注册表，buckets为升序排列的桶上限
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	v := &HistogramVec{
+		desc:    desc{metricName: name, help: help, labelNames: labelNames},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		values:  make(map[string]*histogramValue),
+	}
+	DefaultRegistry.register(v)
+	return v
+}
+
+// Observe 记录一个观测值
+func (v *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := v.labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hv, ok := v.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(v.buckets))}
+		v.values[key] = hv
+	}
+	if i, _ := slices.BinarySearch(v.buckets, value); i < len(v.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += value
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(v.values) {
+		hv := v.values[key]
+		var cumulative uint64
+		for i, upperBound := range v.buckets {
+			cumulative += hv.counts[i]
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.formatLabels(key, "le", formatFloat(upperBound)), cumulative)
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", v.metricName, v.formatLabels(key, "le", "+Inf"), hv.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", v.metricName, v.formatLabels(key), formatFloat(hv.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", v.metricName, v.formatLabels(key), hv.count)
+	}
+}
+
+// GaugeFunc 在输出时才计算取值的仪表盘指标
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc 创建仪表盘指标，并注册到缺省的注册表，fn返回NaN时不输出该指标的取值
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{metricName: name, help: help},
+		fn:   fn,
+	}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	if value := g.fn(); !math.IsNaN(value) {
+		_, _ = fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
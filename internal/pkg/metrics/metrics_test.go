@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry 创建独立的注册表并注册指标，避免测试之间相互影响
+func newTestRegistry(t *testing.T, collectors ...collector) *Registry {
+	t.Helper()
+	r := &Registry{}
+	for _, c := range collectors {
+		r.register(c)
+	}
+	return r
+}
+
+func writeRegistry(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestHistogramVec(t *testing.T) {
+	v := &HistogramVec{
+		desc:    desc{metricName: "test_duration_seconds", help: "Test duration.", labelNames: []string{"stage"}},
+		buckets: []float64{0.1, 1, 10},
+		values:  make(map[string]*histogramValue),
+	}
+	r := newTestRegistry(t, v)
+
+	// 等于桶上限的值计入该桶，超出所有桶上限的值只计入+Inf
+	for _, value := range []float64{0.05, 0.1, 0.5, 5, 100} {
+		v.Observe(value, "auth")
+	}
+	v.Observe(2, "epg")
+
+	want := `# HELP test_duration_seconds Test duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{stage="auth",le="0.1"} 2
+test_duration_seconds_bucket{stage="auth",le="1"} 3
+test_duration_seconds_bucket{stage="auth",le="10"} 4
+test_duration_seconds_bucket{stage="auth",le="+Inf"} 5
+test_duration_seconds_sum{stage="auth"} 105.65
+test_duration_seconds_count{stage="auth"} 5
+test_duration_seconds_bucket{stage="epg",le="0.1"} 0
+test_duration_seconds_bucket{stage="epg",le="1"} 0
+test_duration_seconds_bucket{stage="epg",le="10"} 1
+test_duration_seconds_bucket{stage="epg",le="+Inf"} 1
+test_duration_seconds_sum{stage="epg"} 2
+test_duration_seconds_count{stage="epg"} 1
+`
+	if got := writeRegistry(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecEscaping(t *testing.T) {
+	v := &CounterVec{
+		desc:   desc{metricName: "test_requests_total", help: "Requests\nwith \\ in help.", labelNames: []string{"path", "code"}},
+		values: make(map[string]float64),
+	}
+	g := &GaugeFunc{
+		desc: desc{metricName: "test_age_seconds", help: "Age."},
+		fn:   func() float64 { return math.NaN() },
+	}
+	r := newTestRegistry(t, v, g)
+
+	v.Inc(`/a"b\c`+"\n", "200")
+	v.Add(2.5, "/z", "304")
+
+	// 按指标名称排序，标签值中的反斜杠、双引号和换行需要转义；取值为NaN的仪表盘只输出描述信息
+	want := `# HELP test_age_seconds Age.
+# TYPE test_age_seconds gauge
+# HELP test_requests_total Requests\nwith \\ in help.
+# TYPE test_requests_total counter
+test_requests_total{path="/a\"b\\c\n",code="200"} 1
+test_requests_total{path="/z",code="304"} 2.5
+`
+	if got := writeRegistry(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecPanics(t *testing.T) {
+	v := &CounterVec{
+		desc:   desc{metricName: "test_total", labelNames: []string{"result"}},
+		values: make(map[string]float64),
+	}
+	tests := []struct {
+		name string
+		fn   func()
+		want string
+	}{
+		{name: "label count", fn: func() { v.Inc("success", "extra") }, want: "expected 1 label values"},
+		{name: "negative delta", fn: func() { v.Add(-1, "success") }, want: "counter cannot decrease"},
+		{name: "duplicate registration", fn: func() {
+			newTestRegistry(t, v, &GaugeFunc{desc: desc{metricName: "test_total"}, fn: func() float64 { return 0 }})
+		}, want: "duplicate metric: test_total"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if msg, _ := r.(string); !strings.Contains(msg, tt.want) {
+					t.Errorf("got panic %v, want %q", r, tt.want)
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter 请求速率限制器，保证相邻两次请求之间的最小时间间隔
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // 相邻两次请求的最小间隔
+	next     time.Time     // 下一次允许请求的时间
+}
+
+// NewRateLimiter 创建速率限制器，ratePerSecond为每秒最多允许的请求数，小于等于0时不限制
+func NewRateLimiter(ratePerSecond float64) *RateLimiter {
+	var interval time.Duration
+	if ratePerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / ratePerSecond)
+	}
+	return &RateLimiter{
+		interval: interval,
+	}
+}
+
+// Wait 阻塞等待直至允许发起下一次请求，或者ctx被取消
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.interval <= 0 {
+		return ctx.Err()
+	}
+
+	// 预占下一次请求的时间
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+		l.next = now
+	}
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}